@@ -3,92 +3,332 @@ package consistenthash
 import (
 	"fmt"
 	"hash/crc32"
+	"math"
 	"sort"
 	"strconv"
+	"sync"
+	"sync/atomic"
 )
 
 // Hash hash函数，[]byte转uint32
 type Hash func(data []byte) uint32
 
-// Map 一致性哈希算法主数据结构,并发不安全
+// Map 一致性哈希算法主数据结构,并发安全
+// 哈希环以不可变快照的形式发布，Get 无锁读取当前快照；Add、Del 等修改操作串行执行，构建新快照后原子替换
 type Map struct {
-	hash     Hash           //Hash 函数，默认为 crc32.ChecksumIEEE 算法
-	replicas int            //虚拟节点倍数
-	keys     []int          // 哈希环
-	hashMap  map[int]string //虚拟节点与真实节点的映射表,键是虚拟节点的哈希值，值是真实节点的名称
+	hash Hash         //Hash 函数，默认为 crc32.ChecksumIEEE 算法
+	mu   sync.Mutex   //串行化修改操作
+	ring atomic.Value //当前哈希环快照 *ring
+}
+
+// ring 哈希环快照，发布后不再修改
+type ring struct {
+	replicas   int               //虚拟节点倍数
+	keys       []int             // 哈希环，不同虚拟节点哈希值相同（碰撞）时保留重复的哈希值
+	hashMap    map[int][]string  //虚拟节点与真实节点的映射表,键是虚拟节点的哈希值，值是该位置上的真实节点，按名称排序
+	nodes      map[string]int    //真实节点及其权重
+	weights    int               //所有真实节点的权重之和
+	loadFactor float64           //有界负载系数，节点负载不能超过平均负载的 loadFactor 倍，<= 0 表示不限制
+	loads      map[string]*int64 //真实节点当前处理中的请求数，原子操作，快照之间共享，使负载在快照替换后保留
 }
 
 // New 构造 Map 允许传入虚拟节点倍数及自定义的哈希函数
 func New(replicas int, fn Hash) *Map {
-	m := &Map{
-		replicas: replicas,
-		hash:     fn,
-		hashMap:  make(map[int]string),
-	}
+	m := &Map{hash: fn}
 	if m.hash == nil { //默认 crc32.ChecksumIEEE 算法
 		m.hash = crc32.ChecksumIEEE
 	}
+	m.ring.Store(&ring{
+		replicas: replicas,
+		hashMap:  make(map[int][]string),
+		nodes:    make(map[string]int),
+		loads:    make(map[string]*int64),
+	})
 	return m
 }
 
-// Add 添加真实节点/机器
+// load 返回当前哈希环快照
+func (m *Map) load() *ring {
+	return m.ring.Load().(*ring)
+}
+
+// update 在当前快照的副本上执行 fn，完成后原子替换为新快照
+func (m *Map) update(fn func(r *ring)) *ring {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := m.load().clone()
+	fn(r)
+	m.ring.Store(r)
+	return r
+}
+
+// Add 添加真实节点/机器，权重均为 1
 func (m *Map) Add(keys ...string) {
-	for _, key := range keys {
-		//创建 m.replicas 个虚拟节点
-		for i := 0; i < m.replicas; i++ {
-			//虚拟节点的名称是：strconv.Itoa(i) + key，即通过添加编号的方式区分不同虚拟节点。
-			hash := int(m.hash([]byte(strconv.Itoa(i) + key)))
-			//添加到环上
-			m.keys = append(m.keys, hash)
-			//在 hashMap 中增加虚拟节点和真实节点的映射关系。
-			m.hashMap[hash] = key
+	r := m.update(func(r *ring) {
+		for _, key := range keys {
+			r.add(m.hash, key, 1)
 		}
-	}
-	//环上的哈希值排序
-	sort.Ints(m.keys)
-	fmt.Printf("哈希环情况[虚拟节点]地址 %#v\n", m.hashMap)
+		//环上的哈希值排序
+		sort.Ints(r.keys)
+	})
+	fmt.Printf("哈希环情况[虚拟节点]地址 %#v\n", r.hashMap)
 }
 
-func (m *Map) Del(key string) {
-	deleteKeys := make(map[int]struct{}, m.replicas)
-	for k, v := range m.hashMap { //k是虚拟节点，v是真实节点
-		if key == v { //要删除的节点
-			deleteKeys[k] = struct{}{}
-			delete(m.hashMap, k)
-		}
-	}
+// AddWeighted 添加带权重的真实节点，虚拟节点数为 replicas * weight，weight <= 0 视为 1
+// 适用于机器配置不一致的集群，如按缓存容量设置权重，容量越大的节点分到的key越多
+func (m *Map) AddWeighted(key string, weight int) {
+	r := m.update(func(r *ring) {
+		r.add(m.hash, key, weight)
+		sort.Ints(r.keys)
+	})
+	fmt.Printf("哈希环情况[虚拟节点]地址 %#v\n", r.hashMap)
+}
 
-	// 存在要删除的节点
-	if len(deleteKeys) > 0 {
-		keys := make([]int, 0, len(m.keys))
-		//遍历hash环
-		for _, v := range m.keys {
-			if _, ok := deleteKeys[v]; !ok { //如果不是要删除的节点，收集起来
-				keys = append(keys, v)
-			}
-		}
-		m.keys = keys
-	}
+// Del 删除真实节点及其虚拟节点，节点的负载随节点一并移出快照
+func (m *Map) Del(key string) {
+	m.update(func(r *ring) {
+		r.del(key)
+	})
 }
 
 // Get 选择节点, 环上与key hash后最相近的节点
+// 开启有界负载时，若该节点负载已达上限，则沿环顺时针选择下一个未达上限的节点
 func (m *Map) Get(key string) string {
-	if len(m.keys) == 0 {
+	r := m.load()
+	if len(r.keys) == 0 {
 		return ""
 	}
 
 	// 计算 key 的哈希值
 	hash := int(m.hash([]byte(key)))
 	// 顺时针找到第一个匹配的虚拟节点的下标,找不到inx为 len(m.keys)，后续取余后，即存储在第一个节点
-	idx := sort.Search(len(m.keys), func(i int) bool {
-		return m.keys[i] >= hash
+	idx := sort.Search(len(r.keys), func(i int) bool {
+		return r.keys[i] >= hash
 	})
 
 	// 返回虚拟节点在hashMap映射到的真实节点，因为是环，所以要取余
-	return m.hashMap[m.keys[idx%len(m.keys)]]
+	node := r.nodeAt(idx)
+	if r.loadFactor <= 0 {
+		return node
+	}
+
+	totalLoad := r.totalLoad()
+	for i := 0; i < len(r.keys); i++ {
+		candidate := r.nodeAt(idx + i)
+		if atomic.LoadInt64(r.loads[candidate])+1 <= r.maxLoad(candidate, totalLoad) {
+			return candidate
+		}
+	}
+	return node
 }
 
-// SetReplicas 设置真实节点的副本数
+// GetN 返回key依次所属的至多 n 个不同真实节点，即环上与key hash后最相近的虚拟节点开始，顺时针遇到的前 n 个真实节点
+// 第一个节点与未开启有界负载时 Get 的结果相同，用于将key复制到多个节点
+func (m *Map) GetN(key string, n int) []string {
+	r := m.load()
+	if len(r.keys) == 0 || n <= 0 {
+		return nil
+	}
+	if n > len(r.nodes) {
+		n = len(r.nodes)
+	}
+
+	hash := int(m.hash([]byte(key)))
+	idx := sort.Search(len(r.keys), func(i int) bool {
+		return r.keys[i] >= hash
+	})
+
+	nodes := make([]string, 0, n)
+	seen := make(map[string]struct{}, n)
+	for i := 0; i < len(r.keys) && len(nodes) < n; i++ {
+		node := r.nodeAt(idx + i)
+		if _, ok := seen[node]; !ok {
+			seen[node] = struct{}{}
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
+
+// SetLoadFactor 设置有界负载系数，开启有界负载一致性哈希（consistent hashing with bounded loads）
+// 节点处理中的请求数不能超过 ceil(按权重分摊的平均负载 * c)，c 应大于 1，c <= 0 表示不限制
+func (m *Map) SetLoadFactor(c float64) {
+	m.update(func(r *ring) {
+		r.loadFactor = c
+	})
+}
+
+// Inc 节点开始处理一个请求，开启有界负载时，应在请求发往 Get 选出的节点前调用
+func (m *Map) Inc(key string) {
+	load, ok := m.load().loads[key]
+	if !ok {
+		return
+	}
+	atomic.AddInt64(load, 1)
+}
+
+// Done 节点处理完一个请求，与 Inc 成对调用
+func (m *Map) Done(key string) {
+	load, ok := m.load().loads[key]
+	if !ok {
+		return
+	}
+	for {
+		n := atomic.LoadInt64(load)
+		if n <= 0 {
+			return
+		}
+		if atomic.CompareAndSwapInt64(load, n, n-1) {
+			return
+		}
+	}
+}
+
+// SetReplicas 设置真实节点的副本数，并按当前真实节点及其权重重建整个哈希环，num <= 0 时忽略
+// 新环构建完成后才替换旧环，保证无论节点在副本数变动前后加入，各节点计算出的归属都一致
 func (m *Map) SetReplicas(num int) {
-	m.replicas = num
+	if num <= 0 {
+		return
+	}
+	m.update(func(r *ring) {
+		r.replicas = num
+		r.keys = make([]int, 0, num*r.weights)
+		r.hashMap = make(map[int][]string, num*r.weights)
+		for key, weight := range r.nodes {
+			r.addVirtualNodes(m.hash, key, weight)
+		}
+		sort.Ints(r.keys)
+	})
+}
+
+// clone 复制快照，真实节点的负载计数在新旧快照间共享
+func (r *ring) clone() *ring {
+	c := *r
+	c.keys = make([]int, len(r.keys))
+	copy(c.keys, r.keys)
+	c.hashMap = make(map[int][]string, len(r.hashMap)) //各位置的真实节点列表不在原地修改，可以共享
+	for k, v := range r.hashMap {
+		c.hashMap[k] = v
+	}
+	c.nodes = make(map[string]int, len(r.nodes))
+	for k, v := range r.nodes {
+		c.nodes[k] = v
+	}
+	c.loads = make(map[string]*int64, len(r.loads))
+	for k, v := range r.loads {
+		c.loads[k] = v
+	}
+	return &c
+}
+
+// add 为真实节点创建 replicas * weight 个虚拟节点，重复添加时以最新的权重为准，调用方负责排序
+func (r *ring) add(hash Hash, key string, weight int) {
+	if weight <= 0 {
+		weight = 1
+	}
+	load, ok := r.loads[key]
+	if ok { //重复添加时保留节点负载，重新创建虚拟节点
+		r.del(key)
+	} else {
+		load = new(int64)
+	}
+	r.nodes[key] = weight
+	r.loads[key] = load
+	r.weights += weight
+	r.addVirtualNodes(hash, key, weight)
+}
+
+// addVirtualNodes 在环上创建真实节点的 replicas * weight 个虚拟节点
+func (r *ring) addVirtualNodes(hash Hash, key string, weight int) {
+	for i := 0; i < r.replicas*weight; i++ {
+		//虚拟节点的名称是：strconv.Itoa(i) + key，即通过添加编号的方式区分不同虚拟节点。
+		h := int(hash([]byte(strconv.Itoa(i) + key)))
+		//添加到环上
+		r.keys = append(r.keys, h)
+		//在 hashMap 中增加虚拟节点和真实节点的映射关系，与其他虚拟节点碰撞时按节点名称排序，名称小的节点拥有该位置
+		owners := r.hashMap[h]
+		i := sort.SearchStrings(owners, key)
+		updated := make([]string, 0, len(owners)+1)
+		updated = append(updated, owners[:i]...)
+		updated = append(updated, key)
+		r.hashMap[h] = append(updated, owners[i:]...)
+	}
+}
+
+// nodeAt 返回环上下标 i 处（取余后）虚拟节点对应的真实节点
+// 碰撞的虚拟节点在 keys 中连续排列，同一哈希值的第 n 个下标对应该位置按名称排序的第 n 个真实节点
+func (r *ring) nodeAt(i int) string {
+	i %= len(r.keys)
+	h := r.keys[i]
+	n := 0
+	for i-n > 0 && r.keys[i-n-1] == h {
+		n++
+	}
+	return r.hashMap[h][n]
+}
+
+// del 删除真实节点及其虚拟节点
+func (r *ring) del(key string) {
+	weight, ok := r.nodes[key]
+	if !ok {
+		return
+	}
+	r.weights -= weight
+	delete(r.nodes, key)
+	delete(r.loads, key)
+
+	// 只删除该节点自己的虚拟节点，同一位置上碰撞的其他节点保留
+	deleteKeys := make(map[int]int, r.replicas*weight) //虚拟节点哈希值 => 要从环上删除的个数
+	//k是虚拟节点，owners是该位置的真实节点
+	for k, owners := range r.hashMap {
+		kept := make([]string, 0, len(owners))
+		for _, v := range owners {
+			if v == key { //要删除的节点
+				deleteKeys[k]++
+			} else {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == len(owners) {
+			continue
+		}
+		if len(kept) == 0 {
+			delete(r.hashMap, k)
+		} else {
+			r.hashMap[k] = kept
+		}
+	}
+
+	// 存在要删除的节点
+	if len(deleteKeys) > 0 {
+		keys := make([]int, 0, len(r.keys))
+		//遍历hash环
+		for _, v := range r.keys {
+			if deleteKeys[v] > 0 { //要删除的节点，每个碰撞的哈希值只删除该节点占用的个数
+				deleteKeys[v]--
+				continue
+			}
+			keys = append(keys, v)
+		}
+		r.keys = keys
+	}
+}
+
+// totalLoad 快照中所有节点处理中的请求数之和
+// 由各节点的负载求和而不单独计数，已删除节点上的负载（如与 Del 并发的 Inc）不会计入
+func (r *ring) totalLoad() int64 {
+	var total int64
+	for _, load := range r.loads {
+		total += atomic.LoadInt64(load)
+	}
+	return total
+}
+
+// maxLoad 计算新增一个请求后节点允许的负载上限，按节点权重分摊
+func (r *ring) maxLoad(key string, totalLoad int64) int64 {
+	if r.weights == 0 {
+		return 0
+	}
+	avg := float64(totalLoad+1) * float64(r.nodes[key]) / float64(r.weights)
+	return int64(math.Ceil(avg * r.loadFactor))
 }
@@ -1,7 +1,11 @@
 package consistenthash
 
 import (
+	"fmt"
+	"reflect"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -53,3 +57,218 @@ func TestHashing(t *testing.T) {
 		}
 	}
 }
+
+func TestBoundedLoad(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 虚拟节点 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("2", "4", "6")
+	hash.SetLoadFactor(1.25)
+
+	// 没有负载时与普通一致性哈希一致
+	if hash.Get("11") != "2" {
+		t.Fatalf("Asking for 11, should have yielded 2")
+	}
+
+	// 节点2 负载达到上限 ceil((2+1)/3*1.25) = 2 后，11 顺时针落到下一个节点 4
+	hash.Inc("2")
+	hash.Inc("2")
+	if hash.Get("11") != "4" {
+		t.Fatalf("Asking for 11 with node 2 overloaded, should have yielded 4")
+	}
+
+	hash.Done("2")
+	hash.Done("2")
+	if hash.Get("11") != "2" {
+		t.Fatalf("Asking for 11 after node 2 finished its requests, should have yielded 2")
+	}
+
+	// 删除节点后负载一并清除，与 Del 并发、落在旧快照上的 Inc 不会计入总负载
+	hash.Inc("2")
+	stale := hash.load()
+	hash.Del("2")
+	atomic.AddInt64(stale.loads["2"], 1)
+	if total := hash.load().totalLoad(); total != 0 {
+		t.Fatalf("Del should drop the load of removed node, got %d", total)
+	}
+}
+
+func TestAddWeighted(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("small")
+	hash.AddWeighted("large", 3)
+
+	if len(hash.load().keys) != 50*4 {
+		t.Fatalf("expect %d virtual nodes, got %d", 50*4, len(hash.load().keys))
+	}
+
+	// 权重为 3 的节点分到的key应明显多于权重为 1 的节点
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[hash.Get(fmt.Sprintf("key%d", i))]++
+	}
+	if ratio := float64(counts["large"]) / float64(counts["small"]); ratio < 2 || ratio > 4.5 {
+		t.Fatalf("large should own about 3 times keys of small, got %v", counts)
+	}
+
+	hash.Del("large")
+	if len(hash.load().keys) != 50 || hash.load().weights != 1 {
+		t.Fatalf("Del should remove all virtual nodes of weighted node")
+	}
+}
+
+func TestSetReplicas(t *testing.T) {
+	hash := New(3, nil)
+	hash.Add("a", "b")
+	hash.AddWeighted("c", 2)
+	hash.SetReplicas(20)
+
+	// 重建后的环应与直接以新副本数构建的环一致
+	want := New(20, nil)
+	want.Add("a", "b")
+	want.AddWeighted("c", 2)
+	if len(hash.load().keys) != len(want.load().keys) {
+		t.Fatalf("expect %d virtual nodes, got %d", len(want.load().keys), len(hash.load().keys))
+	}
+	for i := 0; i < 1000; i++ {
+		key := strconv.Itoa(i)
+		if hash.Get(key) != want.Get(key) {
+			t.Fatalf("key %s: rebuilt ring picks %s, want %s", key, hash.Get(key), want.Get(key))
+		}
+	}
+
+	// 重建后加入的节点同样使用新副本数
+	hash.Add("d")
+	if len(hash.load().keys) != 20*5 {
+		t.Fatalf("expect %d virtual nodes, got %d", 20*5, len(hash.load().keys))
+	}
+}
+
+func TestGetN(t *testing.T) {
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	// 虚拟节点为 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash.Add("2", "4", "6")
+
+	testCases := []struct {
+		key  string
+		n    int
+		want []string
+	}{
+		{"11", 2, []string{"2", "4"}},
+		{"23", 3, []string{"4", "6", "2"}},
+		{"27", 1, []string{"2"}},
+		{"5", 5, []string{"6", "2", "4"}}, // 最多返回全部真实节点
+	}
+	for _, tc := range testCases {
+		if got := hash.GetN(tc.key, tc.n); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("GetN(%s, %d) = %v, want %v", tc.key, tc.n, got, tc.want)
+		}
+		if got := hash.GetN(tc.key, tc.n); got[0] != hash.Get(tc.key) {
+			t.Errorf("GetN(%s) should start with Get(%s)", tc.key, tc.key)
+		}
+	}
+}
+
+// 测试并发读取时修改节点，需配合 go test -race
+func TestConcurrentAccess(t *testing.T) {
+	hash := New(10, nil)
+	hash.SetLoadFactor(1.25)
+	hash.Add("a", "b", "c")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; ; j++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := strconv.Itoa(i*100000 + j)
+				node := hash.Get(key)
+				if node == "" {
+					t.Errorf("Get(%s) returned no node", key)
+					return
+				}
+				hash.Inc(node)
+				hash.GetN(key, 2)
+				hash.Done(node)
+			}
+		}(i)
+	}
+
+	for i := 0; i < 200; i++ {
+		node := fmt.Sprintf("node%d", i%5)
+		hash.AddWeighted(node, i%3+1)
+		if i%7 == 0 {
+			hash.SetReplicas(10 + i%5)
+		}
+		hash.Del(node)
+	}
+	close(stop)
+	wg.Wait()
+
+	// 读写结束后未被删除的节点负载应归零
+	for _, node := range []string{"a", "b", "c"} {
+		if load := atomic.LoadInt64(hash.load().loads[node]); load != 0 {
+			t.Fatalf("expect load of %s to be 0 after all requests done, got %d", node, load)
+		}
+	}
+}
+
+// 测试虚拟节点碰撞，自定义hash函数使不同节点的虚拟节点落在同一位置
+func TestCollision(t *testing.T) {
+	// 虚拟节点 "0a"、"0b" => 0，"1a"、"1b" => 10，"2a"、"2b" => 20，纯数字的key按数值计算
+	collide := func(key []byte) uint32 {
+		if i, err := strconv.Atoi(string(key)); err == nil {
+			return uint32(i)
+		}
+		i, _ := strconv.Atoi(string(key[:len(key)-1]))
+		return uint32(i * 10)
+	}
+
+	// 碰撞时名称小的节点拥有该位置，与添加顺序无关
+	for _, order := range [][]string{{"a", "b"}, {"b", "a"}} {
+		hash := New(3, collide)
+		hash.Add(order...)
+		if len(hash.load().keys) != 6 {
+			t.Fatalf("colliding points should be kept, got keys %v", hash.load().keys)
+		}
+		if hash.Get("5") != "a" || hash.Get("25") != "a" {
+			t.Fatalf("add order %v: colliding points should belong to a", order)
+		}
+		if got := hash.GetN("5", 2); !reflect.DeepEqual(got, []string{"a", "b"}) {
+			t.Fatalf("GetN should visit every colliding node, got %v", got)
+		}
+	}
+
+	// 删除节点只删除其自己的虚拟节点
+	hash := New(3, collide)
+	hash.Add("a", "b")
+	hash.Del("a")
+	if len(hash.load().keys) != 3 || hash.Get("5") != "b" {
+		t.Fatalf("b should own all points after a is removed, keys %v", hash.load().keys)
+	}
+	hash.Add("a")
+	hash.Del("b")
+	if len(hash.load().keys) != 3 || hash.Get("5") != "a" {
+		t.Fatalf("a should own all points after b is removed, keys %v", hash.load().keys)
+	}
+
+	// 有界负载时，位置拥有者负载已满，顺延至碰撞的下一个节点
+	hash.Add("b")
+	hash.SetLoadFactor(1.25)
+	hash.Inc("a")
+	hash.Inc("a")
+	if hash.Get("5") != "b" {
+		t.Fatalf("overloaded a should hand over to colliding b")
+	}
+}
@@ -0,0 +1,77 @@
+package consistenthash
+
+import "sort"
+
+// Jump jump 一致性哈希（Lamping & Veach），根据 key 计算 [0, 桶数) 的桶编号，不需要额外存储哈希环
+// 各节点按名称排序后依次作为桶，带权重的节点占用多个桶
+// 注意：只有增删名称排在最后的节点时迁移的key最少；删除其他节点会使其后的桶整体前移，大部分key在剩余节点间迁移
+// （10 个节点删除中间一个时约 60%），只适合节点只增不减且名称按加入顺序递增的集群
+// 桶不按加入顺序分配，因为各节点观察到的加入、退出历史不同，按加入顺序分配会使各节点对key的归属不一致
+type Jump struct {
+	nodes   map[string]int // 真实节点及其权重
+	buckets []string       // 桶编号对应的真实节点
+}
+
+// NewJump 构造 Jump
+func NewJump() *Jump {
+	return &Jump{nodes: make(map[string]int)}
+}
+
+// Add 添加真实节点，权重均为 1
+func (j *Jump) Add(nodes ...string) {
+	for _, node := range nodes {
+		j.nodes[node] = 1
+	}
+	j.rebuild()
+}
+
+// AddWeighted 添加带权重的真实节点，weight <= 0 视为 1
+func (j *Jump) AddWeighted(node string, weight int) {
+	j.nodes[node] = normWeight(weight)
+	j.rebuild()
+}
+
+// Del 删除真实节点
+func (j *Jump) Del(node string) {
+	if _, ok := j.nodes[node]; !ok {
+		return
+	}
+	delete(j.nodes, node)
+	j.rebuild()
+}
+
+// Get 选择key所属的节点
+func (j *Jump) Get(key string) string {
+	if len(j.buckets) == 0 {
+		return ""
+	}
+	return j.buckets[jumpHash(hash64(key), len(j.buckets))]
+}
+
+// rebuild 按节点名称排序重新分配桶
+func (j *Jump) rebuild() {
+	names := make([]string, 0, len(j.nodes))
+	for node := range j.nodes {
+		names = append(names, node)
+	}
+	sort.Strings(names)
+
+	buckets := make([]string, 0, len(names))
+	for _, node := range names {
+		for i := 0; i < j.nodes[node]; i++ {
+			buckets = append(buckets, node)
+		}
+	}
+	j.buckets = buckets
+}
+
+// jumpHash 将 key 映射到 [0, buckets) 中的一个桶，桶数从 n 增加到 n+1 时只有约 1/(n+1) 的key迁移到新桶
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
@@ -0,0 +1,121 @@
+package consistenthash
+
+import "sort"
+
+const defaultMaglevSize = 65537 // 默认查找表大小，需为质数，且远大于节点数
+
+// Maglev Maglev 哈希（Google Maglev 负载均衡器），每个节点按各自的排列轮流占据查找表的槽位
+// 查找表填满后 Get 为 O(1)，key 在各节点间的分布非常均匀，节点增减时少量不属于该节点的key也会迁移
+type Maglev struct {
+	size  uint64         // 查找表大小
+	nodes map[string]int // 真实节点及其权重
+	table []string       // 查找表，槽位对应的真实节点
+}
+
+// NewMaglev 构造 Maglev，size 为查找表大小，不是质数时取大于它的最小质数，<= 0 时使用 65537
+func NewMaglev(size int) *Maglev {
+	if size <= 0 {
+		size = defaultMaglevSize
+	}
+	return &Maglev{size: nextPrime(uint64(size)), nodes: make(map[string]int)}
+}
+
+// Add 添加真实节点，权重均为 1
+func (m *Maglev) Add(nodes ...string) {
+	for _, node := range nodes {
+		m.nodes[node] = 1
+	}
+	m.populate()
+}
+
+// AddWeighted 添加带权重的真实节点，weight <= 0 视为 1
+func (m *Maglev) AddWeighted(node string, weight int) {
+	m.nodes[node] = normWeight(weight)
+	m.populate()
+}
+
+// Del 删除真实节点
+func (m *Maglev) Del(node string) {
+	if _, ok := m.nodes[node]; !ok {
+		return
+	}
+	delete(m.nodes, node)
+	m.populate()
+}
+
+// Get 选择key所属的节点
+func (m *Maglev) Get(key string) string {
+	if len(m.table) == 0 {
+		return ""
+	}
+	return m.table[hash64(key)%m.size]
+}
+
+// populate 重新填充查找表
+// 每个节点由名称计算出 offset 与 skip，其偏好的槽位依次为 (offset + j*skip) % size
+// 节点按名称排序后轮流占据各自下一个空闲的偏好槽位，每轮占据的槽位数等于节点权重，直至查找表填满
+func (m *Maglev) populate() {
+	if len(m.nodes) == 0 {
+		m.table = nil
+		return
+	}
+	names := make([]string, 0, len(m.nodes))
+	for node := range m.nodes {
+		names = append(names, node)
+	}
+	sort.Strings(names)
+
+	offsets := make([]uint64, len(names))
+	skips := make([]uint64, len(names))
+	for i, node := range names {
+		h := hash64(node)
+		offsets[i] = h % m.size
+		skips[i] = fmix64(h^0x9e3779b97f4a7c15)%(m.size-1) + 1
+	}
+
+	slots := make([]int, m.size)
+	for i := range slots {
+		slots[i] = -1
+	}
+	next := make([]uint64, len(names)) // 每个节点下一个偏好槽位的序号
+	var filled uint64
+	for filled < m.size {
+		for i, node := range names {
+			for w := 0; w < m.nodes[node] && filled < m.size; w++ {
+				c := (offsets[i] + next[i]*skips[i]) % m.size
+				for slots[c] >= 0 {
+					next[i]++
+					c = (offsets[i] + next[i]*skips[i]) % m.size
+				}
+				slots[c] = i
+				next[i]++
+				filled++
+			}
+		}
+	}
+
+	table := make([]string, m.size)
+	for i, n := range slots {
+		table[i] = names[n]
+	}
+	m.table = table
+}
+
+// nextPrime 返回大于等于 n 的最小质数，保证每个节点的偏好排列能遍历查找表的所有槽位
+func nextPrime(n uint64) uint64 {
+	if n <= 2 {
+		return 2
+	}
+	for ; ; n++ {
+		prime := true
+		for i := uint64(2); i*i <= n; i++ {
+			if n%i == 0 {
+				prime = false
+				break
+			}
+		}
+		if prime {
+			return n
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+)
+
+const hashSpace = float64(math.MaxUint32) + 1 // 哈希空间大小，哈希值为 uint32
+
+// Range 哈希环上的一段区间 [Start, End]，哈希值落在区间内的key归属 Node
+type Range struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+	Node  string `json:"node"`
+}
+
+// Size 区间包含的哈希值个数
+func (r Range) Size() uint64 {
+	return uint64(r.End) - uint64(r.Start) + 1
+}
+
+// NodeRanges 真实节点在哈希环上拥有的区间，及其占整个哈希空间的比例
+type NodeRanges struct {
+	Node   string  `json:"node"`
+	Weight int     `json:"weight"`
+	Share  float64 `json:"share"`
+	Ranges []Range `json:"ranges"`
+}
+
+// Move 哈希环变动后归属发生变化的区间，From 为 "" 表示此前环上没有节点，To 为 "" 表示变动后环上没有节点
+type Move struct {
+	Start uint32 `json:"start"`
+	End   uint32 `json:"end"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// Members 返回哈希环上的真实节点，按名称排序
+func (m *Map) Members() []string {
+	return m.load().members()
+}
+
+// members 返回快照中的真实节点，按名称排序
+func (r *ring) members() []string {
+	members := make([]string, 0, len(r.nodes))
+	for node := range r.nodes {
+		members = append(members, node)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Ranges 将整个哈希空间按归属节点划分为若干区间，按哈希值从小到大排列，相邻且归属相同的区间会合并
+// 区间归属按一致性哈希计算，不考虑有界负载；环上没有节点时返回一个归属为 "" 的区间
+func (m *Map) Ranges() []Range {
+	return m.load().ranges()
+}
+
+// ranges 按快照划分哈希空间，见 Map.Ranges
+func (r *ring) ranges() []Range {
+	if len(r.keys) == 0 {
+		return []Range{{Start: 0, End: math.MaxUint32}}
+	}
+
+	ranges := make([]Range, 0, len(r.keys)+1)
+	appendRange := func(start, end uint32, node string) {
+		if n := len(ranges); n > 0 && ranges[n-1].Node == node && uint64(ranges[n-1].End)+1 == uint64(start) {
+			ranges[n-1].End = end
+			return
+		}
+		ranges = append(ranges, Range{Start: start, End: end, Node: node})
+	}
+
+	// 每个虚拟节点拥有 (上一个虚拟节点, 该虚拟节点] 的哈希值，第一个虚拟节点还拥有环尾部回绕的区间
+	first := r.nodeAt(0)
+	var start uint32
+	for i, h := range r.keys {
+		if i > 0 && h == r.keys[i-1] { //碰撞的虚拟节点，位置归属名称最小的节点，已在前一个下标处理
+			continue
+		}
+		appendRange(start, uint32(h), r.nodeAt(i))
+		start = uint32(h) + 1
+		if uint32(h) == math.MaxUint32 {
+			return ranges
+		}
+	}
+	appendRange(start, math.MaxUint32, first)
+	return ranges
+}
+
+// Ownership 返回各真实节点拥有的区间及其占哈希空间的比例，按节点名称排序，用于观察节点间的负载均衡情况
+// 节点及区间取自同一快照，各节点的比例之和为 1
+func (m *Map) Ownership() []NodeRanges {
+	r := m.load()
+	owned := make(map[string]*NodeRanges, len(r.nodes))
+	for node, weight := range r.nodes {
+		owned[node] = &NodeRanges{Node: node, Weight: weight}
+	}
+	for _, rg := range r.ranges() {
+		if n, ok := owned[rg.Node]; ok {
+			n.Ranges = append(n.Ranges, rg)
+			n.Share += float64(rg.Size()) / hashSpace
+		}
+	}
+
+	out := make([]NodeRanges, 0, len(owned))
+	for _, node := range r.members() {
+		out = append(out, *owned[node])
+	}
+	return out
+}
+
+// Diff 对比哈希环变动前后的区间划分，返回归属发生变化的区间，before 与 after 为变动前后 Ranges 的返回值
+func Diff(before, after []Range) []Move {
+	var moves []Move
+	i, j := 0, 0
+	var start uint64
+	for i < len(before) && j < len(after) {
+		end := before[i].End
+		if after[j].End < end {
+			end = after[j].End
+		}
+		if from, to := before[i].Node, after[j].Node; from != to {
+			n := len(moves)
+			if n > 0 && moves[n-1].From == from && moves[n-1].To == to && uint64(moves[n-1].End)+1 == start {
+				moves[n-1].End = end
+			} else {
+				moves = append(moves, Move{Start: uint32(start), End: end, From: from, To: to})
+			}
+		}
+		if before[i].End == end {
+			i++
+		}
+		if after[j].End == end {
+			j++
+		}
+		start = uint64(end) + 1
+	}
+	return moves
+}
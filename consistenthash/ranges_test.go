@@ -0,0 +1,108 @@
+package consistenthash
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func newTestMap() *Map {
+	// 虚拟节点为 2, 4, 6, 12, 14, 16, 22, 24, 26
+	hash := New(3, func(key []byte) uint32 {
+		i, _ := strconv.Atoi(string(key))
+		return uint32(i)
+	})
+	hash.Add("2", "4", "6")
+	return hash
+}
+
+func TestRanges(t *testing.T) {
+	hash := newTestMap()
+	if got := hash.Members(); !reflect.DeepEqual(got, []string{"2", "4", "6"}) {
+		t.Fatalf("unexpected members %v", got)
+	}
+
+	want := []Range{
+		{0, 2, "2"}, {3, 4, "4"}, {5, 6, "6"},
+		{7, 12, "2"}, {13, 14, "4"}, {15, 16, "6"},
+		{17, 22, "2"}, {23, 24, "4"}, {25, 26, "6"},
+		{27, math.MaxUint32, "2"},
+	}
+	ranges := hash.Ranges()
+	if !reflect.DeepEqual(ranges, want) {
+		t.Fatalf("Ranges() = %v, want %v", ranges, want)
+	}
+	// 区间内的key都归属对应节点
+	for _, rg := range ranges[:len(ranges)-1] {
+		for k := rg.Start; k <= rg.End; k++ {
+			if got := hash.Get(strconv.Itoa(int(k))); got != rg.Node {
+				t.Fatalf("key %d in %v should belong to %s, got %s", k, rg, rg.Node, got)
+			}
+		}
+	}
+
+	var total float64
+	for _, n := range hash.Ownership() {
+		total += n.Share
+	}
+	if math.Abs(total-1) > 1e-9 {
+		t.Fatalf("shares should sum to 1, got %v", total)
+	}
+
+	if got := New(3, nil).Ranges(); len(got) != 1 || got[0].Node != "" || got[0].End != math.MaxUint32 {
+		t.Fatalf("empty ring should be a single unowned range, got %v", got)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	hash := newTestMap()
+	old := hash.Ranges()
+
+	// 新增虚拟节点 8, 18, 28
+	hash.Add("8")
+	want := []Move{
+		{7, 8, "2", "8"},
+		{17, 18, "2", "8"},
+		{27, 28, "2", "8"},
+	}
+	if got := Diff(old, hash.Ranges()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Diff() = %v, want %v", got, want)
+	}
+
+	// 删除后变回原来的划分
+	if got := Diff(hash.Ranges(), old); len(got) != 3 || got[0].From != "8" || got[0].To != "2" {
+		t.Fatalf("Diff after removing 8 = %v", got)
+	}
+	if got := Diff(old, old); len(got) != 0 {
+		t.Fatalf("identical rings should have no moves, got %v", got)
+	}
+}
+
+// 测试哈希环并发变动时，Ownership 中各节点的比例之和仍为 1
+func TestOwnershipConcurrent(t *testing.T) {
+	hash := New(50, nil)
+	hash.Add("a", "b")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			hash.Add("c")
+			hash.Del("c")
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		var share float64
+		for _, n := range hash.Ownership() {
+			share += n.Share
+		}
+		if math.Abs(share-1) > 1e-9 {
+			t.Fatalf("shares should sum to 1, got %v", share)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package consistenthash
+
+import (
+	"math"
+	"sort"
+)
+
+// Rendezvous 最高随机权重哈希（rendezvous hashing / HRW）
+// 对每个节点计算 key 与节点组合的得分，得分最高的节点即为key所属节点
+// 节点增减时只有归属该节点的key会迁移，不需要虚拟节点，但 Get 的复杂度为 O(节点数)
+type Rendezvous struct {
+	nodes map[string]rendezvousNode // 真实节点
+}
+
+type rendezvousNode struct {
+	hash   uint64 // 节点名称的哈希值
+	weight int    // 节点权重
+}
+
+// NewRendezvous 构造 Rendezvous
+func NewRendezvous() *Rendezvous {
+	return &Rendezvous{nodes: make(map[string]rendezvousNode)}
+}
+
+// Add 添加真实节点，权重均为 1
+func (r *Rendezvous) Add(nodes ...string) {
+	for _, node := range nodes {
+		r.AddWeighted(node, 1)
+	}
+}
+
+// AddWeighted 添加带权重的真实节点，weight <= 0 视为 1
+func (r *Rendezvous) AddWeighted(node string, weight int) {
+	r.nodes[node] = rendezvousNode{hash: hash64(node), weight: normWeight(weight)}
+}
+
+// Del 删除真实节点
+func (r *Rendezvous) Del(node string) {
+	delete(r.nodes, node)
+}
+
+// Get 选择得分最高的节点，得分相同时取名称较小的节点，保证各节点计算结果一致
+func (r *Rendezvous) Get(key string) string {
+	keyHash := hash64(key)
+	var best string
+	bestScore := math.Inf(-1)
+	for node, n := range r.nodes {
+		score := n.score(keyHash)
+		if score > bestScore || score == bestScore && node < best {
+			best, bestScore = node, score
+		}
+	}
+	return best
+}
+
+// GetN 返回得分最高的至多 n 个节点，按得分从高到低排列
+func (r *Rendezvous) GetN(key string, n int) []string {
+	keyHash := hash64(key)
+	type scored struct {
+		node  string
+		score float64
+	}
+	all := make([]scored, 0, len(r.nodes))
+	for node, rn := range r.nodes {
+		all = append(all, scored{node: node, score: rn.score(keyHash)})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].score != all[j].score {
+			return all[i].score > all[j].score
+		}
+		return all[i].node < all[j].node
+	})
+	if n > len(all) {
+		n = len(all)
+	}
+	nodes := make([]string, 0, n)
+	for _, s := range all[:n] {
+		nodes = append(nodes, s.node)
+	}
+	return nodes
+}
+
+// score 带权重的得分 weight / -ln(u)，u 为 key 与节点组合后映射到 (0,1) 的均匀分布值
+// 节点被选中的概率与权重成正比
+func (n rendezvousNode) score(keyHash uint64) float64 {
+	u := (float64(fmix64(keyHash^n.hash)>>11) + 0.5) / (1 << 53)
+	return float64(n.weight) / -math.Log(u)
+}
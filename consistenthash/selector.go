@@ -0,0 +1,86 @@
+package consistenthash
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// 节点选择算法名称
+const (
+	AlgoRing       = "ring"       // 一致性哈希环，见 Map
+	AlgoRendezvous = "rendezvous" // 最高随机权重哈希，见 Rendezvous
+	AlgoJump       = "jump"       // jump 一致性哈希，只适合节点只增不减的集群，见 Jump
+	AlgoMaglev     = "maglev"     // Maglev 哈希，见 Maglev
+)
+
+// Selector 节点选择算法，根据key从真实节点中选出一个节点
+// Map 并发安全，其余实现只允许并发读取，修改时调用方需自行加锁
+type Selector interface {
+	// Add 添加真实节点，权重均为 1
+	Add(nodes ...string)
+	// AddWeighted 添加带权重的真实节点，权重越大分到的key越多，weight <= 0 视为 1
+	AddWeighted(node string, weight int)
+	// Del 删除真实节点
+	Del(node string)
+	// Get 选择key所属的节点，没有节点时返回 ""
+	Get(key string) string
+}
+
+// MultiSelector 可选接口，支持为key选出多个节点的 Selector，用于将key复制到多个节点
+type MultiSelector interface {
+	Selector
+	// GetN 返回key依次所属的至多 n 个不同节点，第一个为主节点
+	GetN(key string, n int) []string
+}
+
+var (
+	_ MultiSelector = (*Map)(nil)
+	_ MultiSelector = (*Rendezvous)(nil)
+
+	_ Selector = (*Map)(nil)
+	_ Selector = (*Rendezvous)(nil)
+	_ Selector = (*Jump)(nil)
+	_ Selector = (*Maglev)(nil)
+)
+
+// NewSelector 按算法名称构造节点选择器，algo 为空时使用哈希环，replicas 只对哈希环有效
+func NewSelector(algo string, replicas int) (Selector, error) {
+	switch algo {
+	case "", AlgoRing:
+		return New(replicas, nil), nil
+	case AlgoRendezvous:
+		return NewRendezvous(), nil
+	case AlgoJump:
+		return NewJump(), nil
+	case AlgoMaglev:
+		return NewMaglev(0), nil
+	default:
+		return nil, fmt.Errorf("consistenthash: unknown selector algorithm %q", algo)
+	}
+}
+
+// hash64 计算字符串的 64 位哈希，FNV-1a 后再经 fmix64 打散
+// 供 Rendezvous、Jump、Maglev 使用，crc32 是线性的，直接用于这些算法分布不均
+func hash64(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return fmix64(h.Sum64())
+}
+
+// fmix64 MurmurHash3 的 64 位终结函数，使输入的每一位都均匀影响输出
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
+
+// normWeight weight <= 0 视为 1
+func normWeight(weight int) int {
+	if weight <= 0 {
+		return 1
+	}
+	return weight
+}
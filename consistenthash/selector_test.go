@@ -0,0 +1,189 @@
+package consistenthash
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+const selectorTestKeys = 20000
+
+// selectorCases 各节点选择算法，哈希环使用较多的虚拟节点以保证分布
+func selectorCases() map[string]func() Selector {
+	return map[string]func() Selector{
+		AlgoRing:       func() Selector { return New(160, nil) },
+		AlgoRendezvous: func() Selector { return NewRendezvous() },
+		AlgoJump:       func() Selector { return NewJump() },
+		AlgoMaglev:     func() Selector { return NewMaglev(0) },
+	}
+}
+
+func nodeNames(n int) []string {
+	names := make([]string, n)
+	for i := range names {
+		names[i] = fmt.Sprintf("node-%02d", i)
+	}
+	return names
+}
+
+func owners(s Selector) []string {
+	out := make([]string, selectorTestKeys)
+	for i := range out {
+		out[i] = s.Get(fmt.Sprintf("key-%d", i))
+	}
+	return out
+}
+
+func TestSelectorDistribution(t *testing.T) {
+	for algo, newSelector := range selectorCases() {
+		s := newSelector()
+		s.Add(nodeNames(10)...)
+
+		counts := make(map[string]int)
+		for _, node := range owners(s) {
+			counts[node]++
+		}
+		if len(counts) != 10 {
+			t.Fatalf("%s: expect keys on 10 nodes, got %d", algo, len(counts))
+		}
+
+		// 计算各节点key数量的变异系数（标准差 / 均值）
+		mean := float64(selectorTestKeys) / 10
+		var variance float64
+		for _, c := range counts {
+			variance += (float64(c) - mean) * (float64(c) - mean)
+		}
+		cv := math.Sqrt(variance/10) / mean
+		t.Logf("%s: key distribution %v, coefficient of variation %.3f", algo, counts, cv)
+		if cv > 0.25 {
+			t.Errorf("%s: keys are unevenly distributed, coefficient of variation %.3f", algo, cv)
+		}
+	}
+}
+
+func TestSelectorMovement(t *testing.T) {
+	for algo, newSelector := range selectorCases() {
+		s := newSelector()
+		s.Add(nodeNames(10)...)
+		before := owners(s)
+
+		// 新增节点，理想情况下只有约 1/11 的key迁移，且都迁移到新节点
+		added := "node-10"
+		s.Add(added)
+		after := owners(s)
+		moved, toOthers := 0, 0
+		for i := range before {
+			if before[i] != after[i] {
+				moved++
+				if after[i] != added {
+					toOthers++
+				}
+			}
+		}
+		ratio := float64(moved) / selectorTestKeys
+		t.Logf("%s: add node moved %.3f of keys (ideal %.3f), %d not to the new node", algo, ratio, 1.0/11, toOthers)
+		if ratio > 2.0/11 {
+			t.Errorf("%s: adding a node moved too many keys: %.3f", algo, ratio)
+		}
+		if algo != AlgoMaglev && toOthers > 0 {
+			t.Errorf("%s: %d keys moved between old nodes", algo, toOthers)
+		}
+
+		// 删除新增的节点，key应回到原来的节点
+		s.Del(added)
+		restored := owners(s)
+		diff := 0
+		for i := range before {
+			if before[i] != restored[i] {
+				diff++
+			}
+		}
+		if diff > 0 {
+			t.Errorf("%s: %d keys not restored after removing the node", algo, diff)
+		}
+	}
+}
+
+func TestSelectorRemoveMiddle(t *testing.T) {
+	for algo, newSelector := range selectorCases() {
+		s := newSelector()
+		s.Add(nodeNames(10)...)
+		before := owners(s)
+
+		// 删除排在中间的节点，理想情况下只有该节点上约 1/10 的key迁移，其余节点的key不动
+		removed := "node-03"
+		s.Del(removed)
+		after := owners(s)
+		moved, betweenOthers := 0, 0
+		for i := range before {
+			if before[i] != after[i] {
+				moved++
+				if before[i] != removed {
+					betweenOthers++
+				}
+			}
+		}
+		ratio, others := float64(moved)/selectorTestKeys, float64(betweenOthers)/selectorTestKeys
+		t.Logf("%s: remove middle node moved %.3f of keys (ideal %.3f), %.3f between remaining nodes", algo, ratio, 1.0/10, others)
+		if algo == AlgoJump { //Jump 按名称排序分配桶，删除非末尾节点时大部分key在剩余节点间迁移，见 Jump 的说明
+			continue
+		}
+		if others > 0.02 {
+			t.Errorf("%s: removing a middle node moved %.3f of keys between remaining nodes", algo, others)
+		}
+	}
+}
+
+func TestSelectorWeighted(t *testing.T) {
+	for algo, newSelector := range selectorCases() {
+		s := newSelector()
+		s.Add("a", "b")
+		s.AddWeighted("c", 2)
+
+		counts := make(map[string]int)
+		for _, node := range owners(s) {
+			counts[node]++
+		}
+		// c 的权重为 2，应分到约一半的key
+		if share := float64(counts["c"]) / selectorTestKeys; share < 0.4 || share > 0.6 {
+			t.Errorf("%s: weighted node share %.3f, want about 0.5, %v", algo, share, counts)
+		}
+	}
+}
+
+func TestNewSelector(t *testing.T) {
+	for _, algo := range []string{"", AlgoRing, AlgoRendezvous, AlgoJump, AlgoMaglev} {
+		s, err := NewSelector(algo, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.Get("key") != "" {
+			t.Fatalf("%q: empty selector should return \"\"", algo)
+		}
+		s.Add("a")
+		if s.Get("key") != "a" {
+			t.Fatalf("%q: single node should own every key", algo)
+		}
+	}
+	if _, err := NewSelector("unknown", 3); err == nil {
+		t.Fatal("expect error for unknown algorithm")
+	}
+}
+
+func TestRendezvousGetN(t *testing.T) {
+	r := NewRendezvous()
+	r.Add(nodeNames(5)...)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key-%d", i)
+		nodes := r.GetN(key, 3)
+		if len(nodes) != 3 || nodes[0] != r.Get(key) {
+			t.Fatalf("GetN(%s) = %v, should start with %s", key, nodes, r.Get(key))
+		}
+		// 删除主节点后，原第二个节点成为主节点
+		r.Del(nodes[0])
+		if r.Get(key) != nodes[1] {
+			t.Fatalf("%s should fail over to %s, got %s", key, nodes[1], r.Get(key))
+		}
+		r.Add(nodes[0])
+	}
+}
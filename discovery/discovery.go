@@ -0,0 +1,147 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+// Node 节点注册信息，以 JSON 形式存储在服务发现中
+type Node struct {
+	Addr   string `json:"addr"`             //节点地址 ip+端口
+	Weight int    `json:"weight,omitempty"` //节点权重，哈希环上的虚拟节点数按权重倍增，<= 0 视为 1
+}
+
+// Encode 编码为服务发现中存储的注册信息
+func (n Node) Encode() string {
+	b, _ := json.Marshal(n)
+	return string(b)
+}
+
+// ParseNode 解析服务发现中存储的注册信息，兼容只存储了节点地址的旧格式
+func ParseNode(value string) Node {
+	var n Node
+	if strings.HasPrefix(value, "{") && json.Unmarshal([]byte(value), &n) == nil {
+		return n
+	}
+	return Node{Addr: value, Weight: 1}
+}
+
+// Discovery 服务发现，提供集群成员及集群配置信息
+type Discovery interface {
+	// Register 将当前节点注册进集群
+	Register() error
+	// Members 返回集群当前成员 map[节点id]节点注册信息
+	Members() (map[string]Node, error)
+	// Watch 监听集群成员变动，节点加入或注册信息变动时执行 putFun，节点离开时执行 delFun
+	// 回调中 WatchInfo.Key 为节点id，WatchInfo.Value 为节点注册信息，使用 ParseNode 解析。返回的 cancel() 后取消监听
+	Watch(ctx context.Context, putFun, delFun KeyEventFun) context.CancelFunc
+	// GetConfig 获取集群配置，配置不存在时返回 ""
+	GetConfig(key string) (string, error)
+	// WatchConfig 监听集群配置变动，返回的 cancel() 后取消监听
+	WatchConfig(ctx context.Context, key string, putFun, delFun KeyEventFun) context.CancelFunc
+}
+
+// EtcdDiscovery 基于etcd的服务发现，节点注册在 ClusterPrefix 下，节点id为其在etcd中的key
+type EtcdDiscovery struct {
+	register *Register //etcd注册服务
+	ttl      int64     //注册租约时间，单位秒
+}
+
+// NewEtcdDiscovery 构造基于etcd的服务发现，weight 为当前节点在哈希环上的权重，使用前需先调用 InitEtcdService 初始化etcd客户端
+func NewEtcdDiscovery(addr string, weight int, ttl int64) *EtcdDiscovery {
+	register := NewRegister(addr)
+	register.Weight = weight
+	return &EtcdDiscovery{register: register, ttl: ttl}
+}
+
+// Register 在etcd中注册当前节点，并维护租约时效
+func (d *EtcdDiscovery) Register() error {
+	return d.register.Register(d.ttl)
+}
+
+// Deregister 删除当前节点注册信息
+func (d *EtcdDiscovery) Deregister() error {
+	return d.register.RemoveRegister()
+}
+
+// Members 获取etcd中注册的节点信息 map[节点在etcd中的key]节点注册信息
+func (d *EtcdDiscovery) Members() (map[string]Node, error) {
+	return d.register.GetNowNodes()
+}
+
+// Watch 监听 ClusterPrefix 前缀下的节点变动
+func (d *EtcdDiscovery) Watch(ctx context.Context, putFun, delFun KeyEventFun) context.CancelFunc {
+	return EtcdService.WatchPrefix(ctx, ClusterPrefix, putFun, delFun)
+}
+
+// GetConfig 获取etcd中的配置
+func (d *EtcdDiscovery) GetConfig(key string) (string, error) {
+	return EtcdService.GetKey(key)
+}
+
+// WatchConfig 监听etcd中的配置变动
+func (d *EtcdDiscovery) WatchConfig(ctx context.Context, key string, putFun, delFun KeyEventFun) context.CancelFunc {
+	return EtcdService.WatchKey(ctx, key, putFun, delFun)
+}
+
+var _ Discovery = (*EtcdDiscovery)(nil)
+
+// Static 固定节点列表的服务发现，节点id即节点addr，成员及配置不会变动，适用于测试或没有etcd的环境
+type Static struct {
+	members map[string]Node
+	config  map[string]string
+}
+
+// NewStatic 使用固定的节点地址列表构造服务发现，节点权重均为 1
+func NewStatic(addrs ...string) *Static {
+	s := &Static{
+		members: make(map[string]Node, len(addrs)),
+		config:  make(map[string]string),
+	}
+	for _, addr := range addrs {
+		s.members[addr] = Node{Addr: addr, Weight: 1}
+	}
+	return s
+}
+
+// SetWeight 设置节点权重，应在使用前调用
+func (s *Static) SetWeight(addr string, weight int) {
+	s.members[addr] = Node{Addr: addr, Weight: weight}
+}
+
+// SetConfig 设置配置，应在使用前调用
+func (s *Static) SetConfig(key, value string) {
+	s.config[key] = value
+}
+
+// Register 固定节点列表无需注册
+func (s *Static) Register() error {
+	return nil
+}
+
+// Members 返回固定的节点列表
+func (s *Static) Members() (map[string]Node, error) {
+	members := make(map[string]Node, len(s.members))
+	for id, node := range s.members {
+		members[id] = node
+	}
+	return members, nil
+}
+
+// Watch 固定节点列表不会变动，不执行回调
+func (s *Static) Watch(ctx context.Context, putFun, delFun KeyEventFun) context.CancelFunc {
+	return func() {}
+}
+
+// GetConfig 获取通过 SetConfig 设置的配置
+func (s *Static) GetConfig(key string) (string, error) {
+	return s.config[key], nil
+}
+
+// WatchConfig 配置不会变动，不执行回调
+func (s *Static) WatchConfig(ctx context.Context, key string, putFun, delFun KeyEventFun) context.CancelFunc {
+	return func() {}
+}
+
+var _ Discovery = (*Static)(nil)
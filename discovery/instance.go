@@ -1,6 +1,7 @@
 package discovery
 
 const (
-	ClusterPrefix             = "/gee_cache/nodes/"                       //ectd中集群地址信息，/gee_cache/nodes/序号 => ip:port ，序号根据节点数量依次递增
+	ClusterPrefix             = "/gee_cache/nodes/"                       //ectd中集群地址信息，/gee_cache/nodes/序号 => {"addr":"ip:port","weight":权重} ，序号根据节点数量依次递增
 	ConsistentHashReplicasNum = "/gee_cache/consistent_hash_replicas_num" //一致性哈希环一个节点的副本数
+	AuthSecret                = "/gee_cache/auth_secret"                  //节点间请求签名的共享密钥，未配置时不校验
 )
@@ -5,12 +5,14 @@ import (
 	"errors"
 	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"sort"
 	"strconv"
 	"strings"
 )
 
 type Register struct {
 	Addr    string             //当前节点ip+端口
+	Weight  int                //当前节点在哈希环上的权重
 	CurKey  string             //当前节点在etcd中的key
 	cancel  context.CancelFunc //关闭注册(续约)用到的chan
 	leaseId clientv3.LeaseID   //租约id
@@ -51,11 +53,12 @@ func (r *Register) Register(ttl int64) error {
 		return err
 	}
 	nodeIndex := make([]int, 0, len(nodes)+1) //集群已经存在的节点序号
-	for _, etcdKey := range nodes {
+	for etcdKey := range nodes {
 		keySlice := strings.Split(etcdKey, "/")
 		index, _ := strconv.Atoi(keySlice[3])
 		nodeIndex = append(nodeIndex, index)
 	}
+	sort.Ints(nodeIndex)
 
 	r.CurKey = "" //新加入的节点key
 	// 节点在etcd中的key为 /gee_cache/序号 序号根据节点的增加依次递增
@@ -73,21 +76,22 @@ func (r *Register) Register(ttl int64) error {
 		r.CurKey = ClusterPrefix + fmt.Sprintf("%d", len(nodeIndex)+1)
 	}
 
-	// 往etcd中注册新节点
-	_, err = EtcdService.cli.Put(context.TODO(), r.CurKey, r.Addr, clientv3.WithLease(r.leaseId)) //写入 /gee_cache/递增数字 绑定租约
+	// 往etcd中注册新节点，value 为节点地址及权重
+	value := Node{Addr: r.Addr, Weight: r.Weight}.Encode()
+	_, err = EtcdService.cli.Put(context.TODO(), r.CurKey, value, clientv3.WithLease(r.leaseId)) //写入 /gee_cache/递增数字 绑定租约
 
-	fmt.Printf("key %s，value %s，租约%x\n", r.CurKey, r.Addr, r.leaseId)
+	fmt.Printf("key %s，value %s，租约%x\n", r.CurKey, value, r.leaseId)
 	return nil
 }
 
-// GetNowNodes 获取集群注册成功的节点信息 map[节点地址]节点在etcd中的key
-func (r *Register) GetNowNodes() (map[string]string, error) {
+// GetNowNodes 获取集群注册成功的节点信息 map[节点在etcd中的key]节点注册信息
+func (r *Register) GetNowNodes() (map[string]Node, error) {
 	// 获取etcd中当前存在的节点信息 get --prefix /gee_cache/nodes/
 	clusterInfo, err := EtcdService.cli.Get(context.TODO(), ClusterPrefix, clientv3.WithPrefix())
 	if err != nil {
 		return nil, err
 	}
-	nodes := make(map[string]string, clusterInfo.Count)
+	nodes := make(map[string]Node, clusterInfo.Count)
 	for _, kv := range clusterInfo.Kvs {
 		// 校验格式
 		keyStr := string(kv.Key)
@@ -100,7 +104,7 @@ func (r *Register) GetNowNodes() (map[string]string, error) {
 		if err != nil {
 			return nil, errors.New("etcd 配置出错, 出错的key为：" + keyStr + " 正确格式应为：" + ClusterPrefix + "数字")
 		}
-		nodes[string(kv.Value)] = keyStr
+		nodes[keyStr] = ParseNode(string(kv.Value))
 	}
 	return nodes, nil
 }
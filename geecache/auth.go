@@ -0,0 +1,168 @@
+package geecache
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	authHeader      = "X-Geecache-Auth" // 节点间请求的签名
+	defaultAuthSkew = 30 * time.Second  // 签名时间戳与本地时间允许的最大偏差
+)
+
+// Authenticator 节点间请求认证，httpGetter 发出请求前调用 Sign，HTTPPool.ServeHTTP 处理请求前调用 Verify
+type Authenticator interface {
+	// Sign 为发往远程节点的请求签名
+	Sign(r *http.Request) error
+	// Verify 校验请求是否来自集群成员，返回错误时拒绝请求
+	Verify(r *http.Request) error
+}
+
+// HMACAuth 基于共享密钥的请求签名，签名覆盖请求方法、路径、查询参数、请求体的 SHA-256、时间戳及随机数
+// 时间戳与本地时间相差超过 skew 的请求被拒绝，skew 内同一随机数只能使用一次，防止请求被截获后重放
+// 密钥为空时不签名也不校验，更换密钥后的一个 skew 内仍接受旧密钥的签名，使集群内各节点不必同时更新
+type HMACAuth struct {
+	skew time.Duration
+
+	mu        sync.Mutex
+	secret    []byte
+	prev      []byte               // 更换前的密钥
+	rotatedAt time.Time            // 更换密钥的时间
+	nonces    map[string]time.Time // 已使用的随机数及其过期时间
+	pruneAt   time.Time            // 下次清理过期随机数的时间
+}
+
+// NewHMACAuth 使用共享密钥 secret 构造 HMACAuth，secret 为空时不开启认证
+func NewHMACAuth(secret string) *HMACAuth {
+	return &HMACAuth{skew: defaultAuthSkew, secret: []byte(secret), nonces: make(map[string]time.Time)}
+}
+
+// SetSecret 更换共享密钥
+func (a *HMACAuth) SetSecret(secret string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if secret == string(a.secret) {
+		return
+	}
+	a.prev, a.secret, a.rotatedAt = a.secret, []byte(secret), time.Now()
+}
+
+// Sign 在请求头中写入签名：时间戳.随机数.签名
+func (a *HMACAuth) Sign(r *http.Request) error {
+	a.mu.Lock()
+	secret := a.secret
+	a.mu.Unlock()
+	if len(secret) == 0 {
+		return nil
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	body, err := bodyHash(r)
+	if err != nil {
+		return err
+	}
+	ts, nonce := strconv.FormatInt(time.Now().UnixNano(), 10), hex.EncodeToString(b)
+	r.Header.Set(authHeader, ts+"."+nonce+"."+hex.EncodeToString(sign(secret, r, body, ts, nonce)))
+	return nil
+}
+
+// Verify 校验请求头中的签名，只在读取密钥及记录随机数时加锁，签名计算可并发进行
+func (a *HMACAuth) Verify(r *http.Request) error {
+	a.mu.Lock()
+	secret, prev, rotatedAt := a.secret, a.prev, a.rotatedAt
+	a.mu.Unlock()
+	if len(secret) == 0 {
+		return nil
+	}
+
+	parts := strings.Split(r.Header.Get(authHeader), ".")
+	if len(parts) != 3 {
+		return errors.New("missing or malformed signature")
+	}
+	ts, nonce := parts[0], parts[1]
+	mac, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return errors.New("malformed signature")
+	}
+	unixNano, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return errors.New("malformed timestamp")
+	}
+	now := time.Now()
+	if d := now.Sub(time.Unix(0, unixNano)); d > a.skew || d < -a.skew {
+		return fmt.Errorf("timestamp out of range: %v", d)
+	}
+	body, err := bodyHash(r)
+	if err != nil {
+		return fmt.Errorf("reading request body: %v", err)
+	}
+	if !hmac.Equal(mac, sign(secret, r, body, ts, nonce)) &&
+		(len(prev) == 0 || now.Sub(rotatedAt) > a.skew || !hmac.Equal(mac, sign(prev, r, body, ts, nonce))) {
+		return errors.New("invalid signature")
+	}
+
+	// 签名有效期内拒绝重复的随机数
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.nonces[nonce]; ok {
+		return errors.New("replayed request")
+	}
+	if now.After(a.pruneAt) {
+		for n, expire := range a.nonces {
+			if now.After(expire) {
+				delete(a.nonces, n)
+			}
+		}
+		a.pruneAt = now.Add(a.skew)
+	}
+	a.nonces[nonce] = time.Unix(0, unixNano).Add(a.skew)
+	return nil
+}
+
+// sign 计算请求方法、路径、查询参数、请求体哈希、时间戳及随机数的 HMAC-SHA256，防止请求体在传输中被替换
+func sign(secret []byte, r *http.Request, body []byte, ts, nonce string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(r.Method + "\n" + r.URL.Path + "\n" + r.URL.RawQuery + "\n" + hex.EncodeToString(body) + "\n" + ts + "\n" + nonce))
+	return mac.Sum(nil)
+}
+
+// bodyHash 计算请求体的 SHA-256，读取后恢复请求体，供发送或后续处理使用
+func bodyHash(r *http.Request) ([]byte, error) {
+	var body []byte
+	var err error
+	switch {
+	case r.GetBody != nil: //客户端请求，读取请求体的副本
+		var rc io.ReadCloser
+		if rc, err = r.GetBody(); err != nil {
+			return nil, err
+		}
+		body, err = ioutil.ReadAll(rc)
+		rc.Close()
+	case r.Body != nil && r.Body != http.NoBody:
+		body, err = ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	return sum[:], nil
+}
+
+var _ Authenticator = (*HMACAuth)(nil)
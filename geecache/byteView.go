@@ -1,8 +1,11 @@
 package geecache
 
+import "time"
+
 // ByteView 不可变字节视图，缓存值。选择 byte 类型是为了能够支持任意的数据类型的存储，例如字符串、图片等
 type ByteView struct {
-	b []byte //存储真实的缓存值，只读，可通过ByteSlice()返回该值拷贝，防止缓存值被外部程序修改
+	b []byte    //存储真实的缓存值，只读，可通过ByteSlice()返回该值拷贝，防止缓存值被外部程序修改
+	e time.Time //过期时间，零值表示永不过期
 }
 
 // Len 返回占用的内存大小。实现 lru.Value 接口。
@@ -20,8 +23,29 @@ func (v ByteView) String() string {
 	return string(v.b)
 }
 
+// Expire 返回缓存值的过期时间，零值表示永不过期
+func (v ByteView) Expire() time.Time {
+	return v.e
+}
+
 func cloneBytes(b []byte) []byte {
 	c := make([]byte, len(b))
 	copy(c, b)
 	return c
 }
+
+// expireToUnixNano 过期时间转换为节点间传输用的纳秒时间戳，0 表示永不过期
+func expireToUnixNano(e time.Time) int64 {
+	if e.IsZero() {
+		return 0
+	}
+	return e.UnixNano()
+}
+
+// expireFromUnixNano 节点间传输的纳秒时间戳转换为过期时间
+func expireFromUnixNano(n int64) time.Time {
+	if n == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, n)
+}
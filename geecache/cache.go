@@ -12,14 +12,14 @@ type cache struct {
 	cacheBytes int64
 }
 
-// add 添加缓存
+// add 添加缓存，value 设置了过期时间时按其过期
 func (c *cache) add(key string, value ByteView) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	if c.lru == nil { //延迟初始化
 		c.lru = lru.New(c.cacheBytes, nil)
 	}
-	c.lru.Add(key, value)
+	c.lru.AddWithExpire(key, value, value.Expire())
 }
 
 // get 获取缓存
@@ -36,3 +36,52 @@ func (c *cache) get(key string) (value ByteView, ok bool) {
 
 	return
 }
+
+// bytes 返回缓存当前使用的内存
+func (c *cache) bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return 0
+	}
+	return c.lru.Bytes()
+}
+
+// removeOldest 淘汰最近最少访问的kv
+func (c *cache) removeOldest() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.lru.RemoveOldest()
+	}
+}
+
+// cacheEntry 缓存中的一个kv
+type cacheEntry struct {
+	key   string
+	value ByteView
+}
+
+// entries 返回缓存中所有未过期的kv，按最近访问从新到旧排列
+func (c *cache) entries() []cacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru == nil {
+		return nil
+	}
+	entries := make([]cacheEntry, 0, c.lru.Len())
+	c.lru.Range(func(key string, value lru.Value) bool {
+		entries = append(entries, cacheEntry{key: key, value: value.(ByteView)})
+		return true
+	})
+	return entries
+}
+
+// remove 移除缓存
+func (c *cache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lru != nil {
+		c.lru.Remove(key)
+	}
+}
@@ -1,11 +1,16 @@
 package geecache
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	pb "geecache/geecachepb"
 	"geecache/singleflight"
 	"log"
+	"math/rand"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -13,32 +18,87 @@ var (
 	groups = make(map[string]*Group)
 )
 
+// ErrNotFound 数据源中不存在该key。Getter 返回的错误满足 errors.Is(err, ErrNotFound) 时，Group 会缓存该结果（负缓存），
+// 在负缓存有效期内再次获取该key直接返回 ErrNotFound，不再访问数据源
+var ErrNotFound = errors.New("geecache: key not found")
+
+const (
+	hotCacheRatio      = 8               // 热点缓存超过本地缓存的 1/hotCacheRatio 时优先淘汰热点缓存
+	hotCacheSample     = 10              // 从远程节点获取的value，按 1/hotCacheSample 的概率放入热点缓存
+	negCacheRatio      = 16              // 负缓存占命名空间缓存上限的 1/negCacheRatio
+	defaultNegativeTTL = 5 * time.Second // 负缓存默认有效期
+	replicateTimeout   = 3 * time.Second // 向副本节点写入的超时时间
+)
+
 // Getter 获取key对应的数据，Get方法应封装key数据源的获取逻辑
+// ctx 被取消时（所有等待该key的调用方都已放弃）应尽快返回
 type Getter interface {
-	Get(key string) ([]byte, error)
+	Get(ctx context.Context, key string) ([]byte, error)
 }
 
 // GetterFunc 类型，实现 Getter 接口
 // 该类型是个方法，根据key获取value值时调用该方法，在方法内用户可自定义key的数据源
-type GetterFunc func(key string) ([]byte, error)
+type GetterFunc func(ctx context.Context, key string) ([]byte, error)
 
 // Get 实现 Getter 接口
-func (f GetterFunc) Get(key string) ([]byte, error) {
-	return f(key)
+func (f GetterFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	return f(ctx, key)
+}
+
+// ExpireGetter 可选接口，Getter 同时实现该接口时，Group 使用其返回的过期时间缓存value
+type ExpireGetter interface {
+	// GetWithExpire 返回key对应的数据及其过期时间，expire为零值表示永不过期
+	GetWithExpire(ctx context.Context, key string) (value []byte, expire time.Time, err error)
+}
+
+// ExpireGetterFunc 类型，实现 Getter 和 ExpireGetter 接口
+type ExpireGetterFunc func(ctx context.Context, key string) ([]byte, time.Time, error)
+
+// Get 实现 Getter 接口，忽略过期时间
+func (f ExpireGetterFunc) Get(ctx context.Context, key string) ([]byte, error) {
+	value, _, err := f(ctx, key)
+	return value, err
+}
+
+// GetWithExpire 实现 ExpireGetter 接口
+func (f ExpireGetterFunc) GetWithExpire(ctx context.Context, key string) ([]byte, time.Time, error) {
+	return f(ctx, key)
+}
+
+// Result Group.GetMany 中单个key的获取结果
+type Result struct {
+	Key   string
+	Value ByteView
+	Err   error
+}
+
+// HedgeStats 对冲请求计数
+type HedgeStats struct {
+	Fired int64 // 发出对冲请求的次数
+	Won   int64 // 对冲请求先于原请求返回的次数
 }
 
 // Group 缓存命名空间，可以为不同数据创建不同的命名空间
 type Group struct {
+	hedges    HedgeStats          //对冲请求计数，原子操作，放在首位保证 32 位平台上 64 位对齐
 	name      string              //命名空间名
 	getter    Getter              //缓存未命中时执行的回调，用户根据数据源编写回调逻辑
-	mainCache cache               //管理缓存的实例
+	mainCache cache               //管理缓存的实例，存放所属节点为自身的key
+	hotCache  cache               //热点缓存，存放部分从远程节点获取的key，减少对所属节点的请求
+	negCache  cache               //负缓存，存放数据源中不存在的key，防止大量请求不存在的key时穿透到数据源
 	peers     PeerPicker          //节点选择器，选择key在哈希环中应该映射的节点
 	loader    *singleflight.Group //防止缓存穿透、击穿
+
+	cacheBytes  int64         //本地缓存与热点缓存共用的内存上限，<= 0 表示不限制
+	negativeTTL time.Duration //负缓存有效期，<= 0 表示不缓存不存在的key
+	replication int           //副本数，key存放的节点数（包含主节点），<= 1 表示不复制
+	hedgeDelay  time.Duration //远程节点超过该时间未返回时发出对冲请求，<= 0 表示不对冲
 }
 
 // NewGroup 构建命名空间，每个命名空间管理一个缓存实例
 // @param name 命名空间名
-// @param cacheBytes 该命名空间缓存上限，单位字节，超过采用lru策略淘汰
+// @param cacheBytes 该命名空间缓存上限，单位字节，其中 1/negCacheRatio 划给负缓存，其余由本地缓存与热点缓存共用，
+// 超过后按lru策略淘汰，热点缓存超过本地缓存的 1/hotCacheRatio 时淘汰热点缓存，否则淘汰本地缓存
 // @param getter 获取数据的回调方法
 func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 	if getter == nil {
@@ -47,11 +107,14 @@ func NewGroup(name string, cacheBytes int64, getter Getter) *Group {
 
 	mu.Lock()
 	defer mu.Unlock()
+	negBytes := cacheBytes / negCacheRatio
 	g := &Group{
-		name:      name,
-		getter:    getter,
-		mainCache: cache{cacheBytes: cacheBytes},
-		loader:    &singleflight.Group{},
+		name:        name,
+		getter:      getter,
+		cacheBytes:  cacheBytes - negBytes, //本地缓存与热点缓存不单独设上限，由 evict 统一淘汰
+		negCache:    cache{cacheBytes: negBytes},
+		loader:      &singleflight.Group{},
+		negativeTTL: defaultNegativeTTL,
 	}
 	groups[name] = g
 	return g
@@ -73,58 +136,442 @@ func (g *Group) RegisterPeers(peers PeerPicker) {
 	g.peers = peers
 }
 
-// Get 根据key获取缓存中对应的value
-func (g *Group) Get(key string) (ByteView, error) {
+// SetNegativeTTL 设置负缓存有效期，<= 0 表示不缓存不存在的key，应在使用 Group 前调用
+func (g *Group) SetNegativeTTL(ttl time.Duration) {
+	g.negativeTTL = ttl
+}
+
+// SetReplication 设置副本数，key存放在 n 个节点上，<= 1 表示不复制，应在使用 Group 前调用
+// 主节点从数据源加载后异步写入其余副本节点，主节点请求失败时依次从副本节点获取
+// 需要 PeerPicker 实现 ReplicaPicker 接口，否则不生效
+func (g *Group) SetReplication(n int) {
+	g.replication = n
+}
+
+// SetHedgeDelay 开启对冲请求，向远程节点获取key超过 delay 仍未返回时，再向下一个副本节点（没有时为本地数据源）发起请求，
+// 采用先返回的结果并取消另一个请求，用于降低偶发慢节点造成的尾延迟，<= 0 表示不开启，应在使用 Group 前调用
+func (g *Group) SetHedgeDelay(delay time.Duration) {
+	g.hedgeDelay = delay
+}
+
+// HedgeStats 返回对冲请求计数
+func (g *Group) HedgeStats() HedgeStats {
+	return HedgeStats{
+		Fired: atomic.LoadInt64(&g.hedges.Fired),
+		Won:   atomic.LoadInt64(&g.hedges.Won),
+	}
+}
+
+// Get 根据key获取缓存中对应的value，ctx 取消或超时后立即返回
+func (g *Group) Get(ctx context.Context, key string) (ByteView, error) {
 	if key == "" {
 		return ByteView{}, fmt.Errorf("key is required")
 	}
 
+	if v, ok := g.lookupCache(key); ok {
+		return v, nil
+	}
+	if g.lookupNegCache(key) {
+		return ByteView{}, ErrNotFound
+	}
+
+	return g.load(ctx, key) //没有本地缓存则尝试载入缓存
+}
+
+// GetMany 批量获取key，返回的结果与keys一一对应
+// 未命中本地缓存的key按所属节点分组，每个远程节点只发起一次批量请求，所属节点为自身的key并发加载
+func (g *Group) GetMany(ctx context.Context, keys []string) []Result {
+	results := make([]Result, len(keys))
+	peerKeys := make(map[PeerGetter][]int) // 远程节点 => 该节点负责的key在keys中的下标
+	var localKeys []int                    // 所属节点为自身的key在keys中的下标
+	for i, key := range keys {
+		results[i].Key = key
+		if key == "" {
+			results[i].Err = fmt.Errorf("key is required")
+			continue
+		}
+		if v, ok := g.lookupCache(key); ok {
+			results[i].Value = v
+			continue
+		}
+		if g.lookupNegCache(key) {
+			results[i].Err = ErrNotFound
+			continue
+		}
+		if g.peers != nil {
+			if peer, ok := g.peers.PickPeer(key); ok {
+				peerKeys[peer] = append(peerKeys[peer], i)
+				continue
+			}
+		}
+		localKeys = append(localKeys, i)
+	}
+
+	var wg sync.WaitGroup
+	for peer, idx := range peerKeys {
+		wg.Add(1)
+		go func(peer PeerGetter, idx []int) {
+			defer wg.Done()
+			g.getManyFromPeer(ctx, peer, idx, results)
+		}(peer, idx)
+	}
+	for _, i := range localKeys {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].Value, results[i].Err = g.getLocally(ctx, results[i].Key)
+		}(i)
+	}
+	wg.Wait()
+	return results
+}
+
+// getManyFromPeer 从远程节点批量获取 results 中下标为 idx 的key，请求失败时从本地加载
+func (g *Group) getManyFromPeer(ctx context.Context, peer PeerGetter, idx []int, results []Result) {
+	req := &pb.BatchRequest{Group: g.name, Keys: make([]string, 0, len(idx))}
+	for _, i := range idx {
+		req.Keys = append(req.Keys, results[i].Key)
+	}
+	res := &pb.BatchResponse{}
+	err := peer.GetMany(ctx, req, res)
+	if err == nil {
+		items := make(map[string]*pb.BatchResult, len(res.GetResults()))
+		for _, item := range res.GetResults() {
+			items[item.GetKey()] = item
+		}
+		for _, i := range idx {
+			item, ok := items[results[i].Key]
+			switch {
+			case !ok:
+				results[i].Err = fmt.Errorf("peer returned no result for key %s", results[i].Key)
+			case item.GetNotFound():
+				g.populateNegCache(results[i].Key, expireFromUnixNano(item.GetExpire()))
+				results[i].Err = ErrNotFound
+			case item.GetError() != "":
+				results[i].Err = errors.New(item.GetError())
+			default:
+				value := ByteView{b: item.GetValue(), e: expireFromUnixNano(item.GetExpire())}
+				g.maybePopulateHotCache(results[i].Key, value)
+				results[i].Value = value
+			}
+		}
+		return
+	}
+
+	if ctx.Err() != nil {
+		for _, i := range idx {
+			results[i].Err = ctx.Err()
+		}
+		return
+	}
+	log.Println("[GeeCache] Failed to get many from peer", err)
+	var wg sync.WaitGroup
+	for _, i := range idx {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i].Value, results[i].Err = g.getLocally(ctx, results[i].Key)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// lookupCache 查找本地缓存及热点缓存
+func (g *Group) lookupCache(key string) (ByteView, bool) {
 	if v, ok := g.mainCache.get(key); ok { //命中本地缓存
 		log.Println("[GeeCache] hit")
-		return v, nil
+		return v, true
+	}
+	if v, ok := g.hotCache.get(key); ok { //命中热点缓存
+		log.Println("[GeeCache] hot hit")
+		return v, true
 	}
+	return ByteView{}, false
+}
 
-	return g.load(key) //没有本地缓存则尝试载入缓存
+// lookupNegCache 查找负缓存，命中表示数据源中不存在该key
+func (g *Group) lookupNegCache(key string) bool {
+	if _, ok := g.negCache.get(key); ok {
+		log.Println("[GeeCache] negative hit")
+		return true
+	}
+	return false
 }
 
-// load 加载缓存
-func (g *Group) load(key string) (value ByteView, err error) {
-	if g.peers != nil {
-		// PickPeer 会根据传入的key hash计算选择拿到对应远程节点http客户端
-		if peer, ok := g.peers.PickPeer(key); ok {
-			if value, err = g.getFromPeer(peer, key); err == nil {
-				return value, nil
+// Set 主动写入key对应的缓存，永不过期，写入key所属节点并确认后返回
+func (g *Group) Set(ctx context.Context, key string, value []byte) error {
+	return g.SetWithExpire(ctx, key, value, time.Time{})
+}
+
+// SetWithExpire 主动写入key对应的缓存，在expire时刻过期
+// 通过一致性哈希选出key所属节点，所属节点为自身时直接写入本地缓存，否则写入远程节点
+// 写入成功后通知集群中其他节点移除热点缓存中的旧值
+func (g *Group) SetWithExpire(ctx context.Context, key string, value []byte, expire time.Time) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	view := ByteView{b: cloneBytes(value), e: expire}
+	if g.peers == nil {
+		g.setLocally(key, view)
+		return nil
+	}
+
+	owner, ok := g.peers.PickPeer(key)
+	if ok {
+		// 本地可能残留旧值（热点缓存或哈希环变动前写入的），一并移除
+		g.removeLocally(key)
+		if err := g.setToPeer(ctx, owner, key, view); err != nil {
+			return err
+		}
+	} else {
+		g.setLocally(key, view)
+	}
+	g.removeFromPeers(ctx, key, owner)
+	return nil
+}
+
+// setToPeer 用传入的http客户端，将kv写入远程节点
+func (g *Group) setToPeer(ctx context.Context, peer PeerGetter, key string, value ByteView) error {
+	return peer.Set(ctx, &pb.SetRequest{
+		Group:  g.name,
+		Key:    key,
+		Value:  value.b,
+		Expire: expireToUnixNano(value.Expire()),
+	})
+}
+
+// Remove 移除key对应的缓存，用于数据源变更后使缓存失效
+// 先移除本地缓存，再通知key所属节点移除，最后通知集群中其他节点移除可能持有的副本
+func (g *Group) Remove(ctx context.Context, key string) error {
+	if key == "" {
+		return fmt.Errorf("key is required")
+	}
+
+	g.removeLocally(key)
+	if g.peers == nil {
+		return nil
+	}
+
+	owner, ok := g.peers.PickPeer(key)
+	if ok {
+		if err := g.removeFromPeer(ctx, owner, key); err != nil {
+			return err
+		}
+	}
+	g.removeFromPeers(ctx, key, owner)
+	return nil
+}
+
+// removeFromPeers 通知集群中除skip外的其他节点移除key，尽力通知，失败只记录日志
+func (g *Group) removeFromPeers(ctx context.Context, key string, skip PeerGetter) {
+	var wg sync.WaitGroup
+	for _, peer := range g.peers.AllPeers() {
+		if peer == skip {
+			continue
+		}
+		wg.Add(1)
+		go func(peer PeerGetter) {
+			defer wg.Done()
+			if err := g.removeFromPeer(ctx, peer, key); err != nil {
+				log.Println("[GeeCache] Failed to remove from peer", err)
 			}
-			log.Println("[GeeCache] Failed to get from peer", err)
+		}(peer)
+	}
+	wg.Wait()
+}
+
+// removeFromPeer 用传入的http客户端，通知远程节点移除key
+func (g *Group) removeFromPeer(ctx context.Context, peer PeerGetter, key string) error {
+	return peer.Remove(ctx, &pb.Request{
+		Group: g.name,
+		Key:   key,
+	})
+}
+
+// removeLocally 移除本地缓存，包括热点缓存和负缓存
+func (g *Group) removeLocally(key string) {
+	g.mainCache.remove(key)
+	g.hotCache.remove(key)
+	g.negCache.remove(key)
+}
+
+// setLocally 写入本地缓存，并移除热点缓存和负缓存中可能存在的旧值
+func (g *Group) setLocally(key string, value ByteView) {
+	g.hotCache.remove(key)
+	g.negCache.remove(key)
+	g.populateCache(key, value)
+}
+
+// setManyLocally 批量写入本地缓存，用于接收其他节点迁移过来的key
+func (g *Group) setManyLocally(entries []*pb.BulkEntry) {
+	for _, e := range entries {
+		g.setLocally(e.GetKey(), ByteView{b: e.GetValue(), e: expireFromUnixNano(e.GetExpire())})
+	}
+}
+
+// load 加载缓存，依次请求key所属的节点，请求失败时尝试下一个副本节点，轮到自身或全部失败时从本地加载
+func (g *Group) load(ctx context.Context, key string) (value ByteView, err error) {
+	owners := g.pickOwners(key)
+	for i := 0; i < len(owners) && owners[i] != nil; i++ { //owners[i] 为 nil 表示自身是key的所属节点之一
+		var backup PeerGetter // 对冲请求的目标，下一个所属节点，nil 表示本地
+		if i+1 < len(owners) {
+			backup = owners[i+1]
+		}
+		var hedged bool
+		// 所属节点确认key不存在时，无需再从本地加载
+		if value, hedged, err = g.hedgedGet(ctx, key, owners[i], backup); err == nil || errors.Is(err, ErrNotFound) {
+			return value, err
+		}
+		if ctx.Err() != nil { //调用方已放弃，无需再从本地加载
+			return ByteView{}, ctx.Err()
+		}
+		log.Println("[GeeCache] Failed to get from peer", err)
+		if hedged { //对冲请求也已失败，跳过 backup
+			if backup == nil {
+				return ByteView{}, err
+			}
+			i++
 		}
 	}
-	return g.getLocally(key)
+	return g.getLocally(ctx, key)
 }
 
-// getFromPeer 用传入的http客户端，获取key
-func (g *Group) getFromPeer(peer PeerGetter, key string) (ByteView, error) {
+// hedgedGet 从远程节点 peer 获取key，开启对冲请求时，peer 超过 hedgeDelay 未返回则同时向 backup 获取，nil 表示从本地加载
+// 返回先成功（或确认key不存在）的结果并取消另一个请求，两者都失败时返回后失败的错误，hedged 表示是否发出了对冲请求
+func (g *Group) hedgedGet(ctx context.Context, key string, peer, backup PeerGetter) (value ByteView, hedged bool, err error) {
+	if g.hedgeDelay <= 0 {
+		value, err = g.getFromPeer(ctx, peer, key)
+		return value, false, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() //返回时取消仍未完成的请求
+	type result struct {
+		value ByteView
+		err   error
+		hedge bool
+	}
+	results := make(chan result, 2)
+	go func() {
+		value, err := g.getFromPeer(ctx, peer, key)
+		results <- result{value: value, err: err}
+	}()
+
+	timer := time.NewTimer(g.hedgeDelay)
+	defer timer.Stop()
+	for pending := 1; pending > 0; {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil || errors.Is(r.err, ErrNotFound) {
+				if r.hedge {
+					atomic.AddInt64(&g.hedges.Won, 1)
+				}
+				return r.value, hedged, r.err
+			}
+			err = r.err
+		case <-timer.C: //原请求先失败时循环已结束，不会再对冲
+			hedged = true
+			pending++
+			atomic.AddInt64(&g.hedges.Fired, 1)
+			go func() {
+				var r result
+				if backup == nil {
+					r.value, r.err = g.getLocally(ctx, key)
+				} else {
+					r.value, r.err = g.getFromPeer(ctx, backup, key)
+				}
+				r.hedge = true
+				results <- r
+			}()
+		}
+	}
+	return ByteView{}, hedged, err
+}
+
+// pickOwners 返回key依次所属的节点客户端，未开启复制时只有主节点，nil 表示自身
+func (g *Group) pickOwners(key string) []PeerGetter {
+	if g.peers == nil {
+		return []PeerGetter{nil}
+	}
+	if rp, ok := g.peers.(ReplicaPicker); ok && g.replication > 1 {
+		if owners := rp.PickReplicas(key, g.replication); len(owners) > 0 {
+			return owners
+		}
+		return []PeerGetter{nil}
+	}
+	// PickPeer 会根据传入的key hash计算选择拿到对应远程节点http客户端
+	if peer, ok := g.peers.PickPeer(key); ok {
+		return []PeerGetter{peer}
+	}
+	return []PeerGetter{nil}
+}
+
+// replicate 自身为key的主节点时，将从数据源加载的kv异步写入其余副本节点，写入失败只记录日志
+func (g *Group) replicate(key string, value ByteView) {
+	if g.replication <= 1 {
+		return
+	}
+	owners := g.pickOwners(key)
+	if owners[0] != nil {
+		return
+	}
+	for _, peer := range owners[1:] {
+		go func(peer PeerGetter) {
+			ctx, cancel := context.WithTimeout(context.Background(), replicateTimeout)
+			defer cancel()
+			if err := g.setToPeer(ctx, peer, key, value); err != nil {
+				log.Println("[GeeCache] Failed to replicate to peer", err)
+			}
+		}(peer)
+	}
+}
+
+// getFromPeer 用传入的http客户端，获取key，按一定概率放入热点缓存，远程节点确认key不存在时放入负缓存
+func (g *Group) getFromPeer(ctx context.Context, peer PeerGetter, key string) (ByteView, error) {
 	req := &pb.Request{
 		Group: g.name,
 		Key:   key,
 	}
 	res := &pb.Response{}
-	err := peer.Get(req, res)
+	err := peer.Get(ctx, req, res)
 	if err != nil {
 		return ByteView{}, err
 	}
-	return ByteView{b: res.Value}, nil
+	if res.NotFound {
+		g.populateNegCache(key, expireFromUnixNano(res.Expire))
+		return ByteView{}, ErrNotFound
+	}
+	value := ByteView{b: res.Value, e: expireFromUnixNano(res.Expire)}
+	g.maybePopulateHotCache(key, value)
+	return value, nil
+}
+
+// maybePopulateHotCache 按 1/hotCacheSample 的概率将从远程节点获取的kv放入热点缓存
+func (g *Group) maybePopulateHotCache(key string, value ByteView) {
+	if rand.Intn(hotCacheSample) == 0 {
+		g.hotCache.add(key, value)
+		g.evict()
+	}
 }
 
 // getLocally 通过 Group.getter 回调加载缓存并放入缓存实例中管理
-func (g *Group) getLocally(key string) (ByteView, error) {
+func (g *Group) getLocally(ctx context.Context, key string) (ByteView, error) {
 	// 确保同个key同时只有1个请求，防止同时大量缓存穿透、击穿
-	viewi, err := g.loader.Do(key, func() (interface{}, error) {
-		bytes, err := g.getter.Get(key)
+	// 某个调用方取消不会中断仍有其他调用方等待的加载
+	viewi, err := g.loader.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		bytes, expire, err := g.getFromGetter(ctx, key)
+		if errors.Is(err, ErrNotFound) {
+			g.populateNegCache(key, time.Time{})
+			return ByteView{}, ErrNotFound
+		}
 		if err != nil {
 			return ByteView{}, err
 		}
-		value := ByteView{b: cloneBytes(bytes)}
+		value := ByteView{b: cloneBytes(bytes), e: expire}
 		g.populateCache(key, value)
+		g.replicate(key, value)
 		return value, nil
 	})
 	if err != nil {
@@ -133,7 +580,97 @@ func (g *Group) getLocally(key string) (ByteView, error) {
 	return viewi.(ByteView), nil
 }
 
+// getFromGetter 调用 Group.getter 获取数据源，getter 实现了 ExpireGetter 时一并返回过期时间
+func (g *Group) getFromGetter(ctx context.Context, key string) ([]byte, time.Time, error) {
+	if eg, ok := g.getter.(ExpireGetter); ok {
+		return eg.GetWithExpire(ctx, key)
+	}
+	bytes, err := g.getter.Get(ctx, key)
+	return bytes, time.Time{}, err
+}
+
 // populateCache 将kv放入缓存实例
 func (g *Group) populateCache(key string, value ByteView) {
 	g.mainCache.add(key, value)
+	g.evict()
+}
+
+// evict 本地缓存与热点缓存共用 Group.cacheBytes，超出时淘汰，热点缓存超过本地缓存的 1/hotCacheRatio 时淘汰热点缓存，
+// 否则淘汰本地缓存，热点缓存可使用本地缓存用不满的内存，内存紧张时最多占本地缓存的 1/hotCacheRatio
+func (g *Group) evict() {
+	if g.cacheBytes <= 0 {
+		return
+	}
+	for {
+		mainBytes, hotBytes := g.mainCache.bytes(), g.hotCache.bytes()
+		if mainBytes+hotBytes <= g.cacheBytes {
+			return
+		}
+		victim := &g.mainCache
+		if hotBytes > mainBytes/hotCacheRatio {
+			victim = &g.hotCache
+		}
+		victim.removeOldest()
+	}
+}
+
+// populateNegCache 将不存在的key放入负缓存，在expire时刻过期，expire为零值时使用 Group.negativeTTL
+func (g *Group) populateNegCache(key string, expire time.Time) {
+	if g.negativeTTL <= 0 {
+		return
+	}
+	if expire.IsZero() {
+		expire = g.negativeExpire()
+	}
+	g.negCache.add(key, ByteView{e: expire})
+}
+
+// negativeExpire 返回当前写入的负缓存的过期时间，未开启负缓存时返回零值
+func (g *Group) negativeExpire() time.Time {
+	if g.negativeTTL <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(g.negativeTTL)
+}
+
+// storedNegativeExpire 返回负缓存中key的过期时间，请求方节点据此缓存，与本节点的负缓存同时过期
+// key不在负缓存中（已被淘汰）时返回 negativeExpire
+func (g *Group) storedNegativeExpire(key string) time.Time {
+	if v, ok := g.negCache.get(key); ok {
+		return v.Expire()
+	}
+	return g.negativeExpire()
+}
+
+// getResponse 获取key并构造返回给请求方节点的 pb.Response，key不存在时 NotFound 为 true
+func (g *Group) getResponse(ctx context.Context, key string) (*pb.Response, error) {
+	view, err := g.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) { //key不存在不视为错误，通过 NotFound 字段告知请求方
+		return &pb.Response{NotFound: true, Expire: expireToUnixNano(g.storedNegativeExpire(key))}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pb.Response{Value: view.ByteSlice(), Expire: expireToUnixNano(view.Expire())}, nil
+}
+
+// getManyResponse 批量获取key并构造返回给请求方节点的 pb.BatchResponse，单个key的错误记录在对应结果中
+func (g *Group) getManyResponse(ctx context.Context, keys []string) *pb.BatchResponse {
+	results := g.GetMany(ctx, keys)
+	out := &pb.BatchResponse{Results: make([]*pb.BatchResult, 0, len(results))}
+	for _, res := range results {
+		item := &pb.BatchResult{Key: res.Key}
+		switch {
+		case errors.Is(res.Err, ErrNotFound):
+			item.NotFound = true
+			item.Expire = expireToUnixNano(g.storedNegativeExpire(res.Key))
+		case res.Err != nil:
+			item.Error = res.Err.Error()
+		default:
+			item.Value = res.Value.ByteSlice()
+			item.Expire = expireToUnixNano(res.Value.Expire())
+		}
+		out.Results = append(out.Results, item)
+	}
+	return out
 }
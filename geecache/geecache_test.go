@@ -1,20 +1,50 @@
 package geecache
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"geecache/consistenthash"
+	"geecache/discovery"
+	pb "geecache/geecachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
 	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // 测试回调函数是否正常工作
 func TestGetter(t *testing.T) {
-	var f Getter = GetterFunc(func(key string) ([]byte, error) {
+	var f Getter = GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
 		return []byte(key), nil
 	})
 
 	expect := []byte("key")
-	if v, _ := f.Get("key"); !reflect.DeepEqual(v, expect) {
+	if v, _ := f.Get(context.Background(), "key"); !reflect.DeepEqual(v, expect) {
 		t.Errorf("callback failed")
 	}
 }
@@ -30,7 +60,7 @@ func TestGet(t *testing.T) {
 	loadCounts := make(map[string]int, len(db))
 	gee := NewGroup("scores", 2<<10, GetterFunc(
 		// 回调方法，从变量db中获取源数据
-		func(key string) ([]byte, error) {
+		func(ctx context.Context, key string) ([]byte, error) {
 			log.Println("[SlowDB] search key", key)
 			if v, ok := db[key]; ok {
 				if _, ok := loadCounts[key]; !ok {
@@ -44,16 +74,1254 @@ func TestGet(t *testing.T) {
 
 	for k, v := range db {
 		// 判断缓存为空的情况下，能否通过回调函数获取到源数据
-		if view, err := gee.Get(k); err != nil || view.String() != v {
+		if view, err := gee.Get(context.Background(), k); err != nil || view.String() != v {
 			t.Fatal("failed to get value of Tom")
 		}
 		// 在缓存已经存在的情况下，是否直接从缓存中获取，如果还是通过回调loadCounts变量对应的key值 > 1
-		if _, err := gee.Get(k); err != nil || loadCounts[k] > 1 {
+		if _, err := gee.Get(context.Background(), k); err != nil || loadCounts[k] > 1 {
 			t.Fatalf("cache %s miss", k)
 		} // cache hit
 	}
 
-	if view, err := gee.Get("unknown"); err == nil {
+	if view, err := gee.Get(context.Background(), "unknown"); err == nil {
 		t.Fatalf("the value of unknow should be empty, but %s got", view)
 	}
 }
+
+// 测试 ExpireGetter 返回的过期时间生效，并在节点间传输时保留
+func TestGetWithExpire(t *testing.T) {
+	loads := 0
+	expire := time.Now().Add(100 * time.Millisecond)
+	gee := NewGroup("expire", 2<<10, ExpireGetterFunc(
+		func(ctx context.Context, key string) ([]byte, time.Time, error) {
+			loads++
+			return []byte(db[key]), expire, nil
+		}))
+
+	if view, err := gee.Get(context.Background(), "Tom"); err != nil || !view.Expire().Equal(expire) {
+		t.Fatalf("expire of Tom should be %v, got %v", expire, view.Expire())
+	}
+
+	// 通过 HTTPPool 获取，过期时间应与数据源一致
+	srv := httptest.NewServer(NewHTTPPool("self", nil))
+	defer srv.Close()
+	view, err := gee.getFromPeer(context.Background(), &httpGetter{baseURL: srv.URL + defaultBasePath}, "Tom")
+	if err != nil || view.String() != "630" || !view.Expire().Equal(expire) {
+		t.Fatalf("peer should keep expire %v, got %v (err %v)", expire, view.Expire(), err)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := gee.Get(context.Background(), "Tom"); err != nil || loads != 2 {
+		t.Fatalf("expired Tom should be reloaded, loads %d", loads)
+	}
+}
+
+// 测试 Group.Remove 及通过 HTTPPool 移除远程节点缓存
+func TestRemove(t *testing.T) {
+	loads := 0
+	gee := NewGroup("remove", 2<<10, GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+
+	gee.Get(context.Background(), "Tom")
+	if err := gee.Remove(context.Background(), "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if gee.Get(context.Background(), "Tom"); loads != 2 {
+		t.Fatalf("removed Tom should be reloaded, loads %d", loads)
+	}
+
+	srv := httptest.NewServer(NewHTTPPool("self", nil))
+	defer srv.Close()
+	if err := gee.removeFromPeer(context.Background(), &httpGetter{baseURL: srv.URL + defaultBasePath}, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if gee.Get(context.Background(), "Tom"); loads != 3 {
+		t.Fatalf("Tom removed by peer should be reloaded, loads %d", loads)
+	}
+}
+
+// 测试 Group.Set 及通过 HTTPPool 写入远程节点缓存
+func TestSet(t *testing.T) {
+	loads := 0
+	gee := NewGroup("set", 2<<10, GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+
+	if err := gee.Set(context.Background(), "Tom", []byte("700")); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get(context.Background(), "Tom"); err != nil || view.String() != "700" || loads != 0 {
+		t.Fatalf("Tom should be 700 without loading, got %s, loads %d", view, loads)
+	}
+
+	srv := httptest.NewServer(NewHTTPPool("self", nil))
+	defer srv.Close()
+	expire := time.Now().Add(time.Minute)
+	if err := gee.setToPeer(context.Background(), &httpGetter{baseURL: srv.URL + defaultBasePath}, "Tom", ByteView{b: []byte("800"), e: expire}); err != nil {
+		t.Fatal(err)
+	}
+	if view, err := gee.Get(context.Background(), "Tom"); err != nil || view.String() != "800" || !view.Expire().Equal(expire) || loads != 0 {
+		t.Fatalf("Tom set by peer should be 800, got %s, loads %d", view, loads)
+	}
+}
+
+// 测试热点缓存的命中与移除
+func TestHotCache(t *testing.T) {
+	loads := 0
+	gee := NewGroup("hot", 2<<10, GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			return []byte(db[key]), nil
+		}))
+	if gee.cacheBytes+gee.negCache.cacheBytes != 2<<10 {
+		t.Fatalf("main and hot caches should share cacheBytes minus the negative cache")
+	}
+
+	gee.hotCache.add("Tom", ByteView{b: []byte("630")})
+	if view, err := gee.Get(context.Background(), "Tom"); err != nil || view.String() != "630" || loads != 0 {
+		t.Fatalf("Tom should hit hot cache, loads %d", loads)
+	}
+
+	gee.Remove(context.Background(), "Tom")
+	if _, ok := gee.hotCache.get("Tom"); ok {
+		t.Fatalf("Remove should drop hot copy of Tom")
+	}
+}
+
+// 测试本地缓存与热点缓存共用内存上限，本地缓存用不满的内存可供热点缓存使用，本地缓存增长时先将热点缓存淘汰至 1/hotCacheRatio
+func TestHotCacheEviction(t *testing.T) {
+	gee := NewGroup("hotEviction", 1600, GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			return nil, nil
+		}))
+	value := ByteView{b: make([]byte, 100)} //每个kv占用 2+100 字节，共用上限为 1600-1600/negCacheRatio=1500
+	used := func() int64 { return gee.mainCache.bytes() + gee.hotCache.bytes() }
+
+	for i := 0; i < 10; i++ {
+		gee.hotCache.add(fmt.Sprintf("h%d", i), value)
+		gee.evict()
+		gee.populateCache(fmt.Sprintf("m%d", i), value)
+	}
+	if used() > gee.cacheBytes || gee.hotCache.bytes() == 0 {
+		t.Fatalf("hot cache should use memory left by the main cache, main %d, hot %d", gee.mainCache.bytes(), gee.hotCache.bytes())
+	}
+	for i := 0; i < 10; i++ {
+		if _, ok := gee.mainCache.get(fmt.Sprintf("m%d", i)); !ok {
+			t.Fatalf("m%d should not be evicted while the hot cache exceeds its share", i)
+		}
+	}
+
+	for i := 10; i < 15; i++ {
+		gee.populateCache(fmt.Sprintf("m%d", i), value)
+	}
+	if used() > gee.cacheBytes || gee.hotCache.bytes() > gee.mainCache.bytes()/hotCacheRatio {
+		t.Fatalf("hot cache should be evicted down to its share first, main %d, hot %d", gee.mainCache.bytes(), gee.hotCache.bytes())
+	}
+	if _, ok := gee.mainCache.get("m0"); ok {
+		t.Fatalf("m0 should be evicted once the hot cache is within its share")
+	}
+	if _, ok := gee.mainCache.get("m14"); !ok {
+		t.Fatalf("m14 should be cached")
+	}
+}
+
+// fakePeer 测试用远程节点，Get 返回固定的值或错误，Set、SetMany 的请求分别写入 sets、bulks
+type fakePeer struct {
+	value string
+	err   error
+	sets  chan *pb.SetRequest
+	bulks chan *pb.BulkSetRequest
+}
+
+func (f *fakePeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if f.err != nil {
+		return f.err
+	}
+	out.Value = []byte(f.value)
+	return nil
+}
+
+func (f *fakePeer) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	return f.err
+}
+
+func (f *fakePeer) Remove(ctx context.Context, in *pb.Request) error {
+	return f.err
+}
+
+func (f *fakePeer) Set(ctx context.Context, in *pb.SetRequest) error {
+	f.sets <- in
+	return f.err
+}
+
+func (f *fakePeer) SetMany(ctx context.Context, in *pb.BulkSetRequest) error {
+	if f.bulks != nil {
+		f.bulks <- in
+	}
+	return f.err
+}
+
+// replicaPicker 测试用 PeerPicker，所有key的所属节点均为 owners，nil 表示自身
+type replicaPicker struct {
+	owners []PeerGetter
+}
+
+func (p *replicaPicker) PickPeer(key string) (PeerGetter, bool) {
+	return p.owners[0], p.owners[0] != nil
+}
+
+func (p *replicaPicker) AllPeers() []PeerGetter {
+	var peers []PeerGetter
+	for _, peer := range p.owners {
+		if peer != nil {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+func (p *replicaPicker) PickReplicas(key string, n int) []PeerGetter {
+	return p.owners
+}
+
+// 测试开启复制后，主节点加载后写入副本节点，主节点请求失败时从副本节点获取
+func TestReplication(t *testing.T) {
+	loads := 0
+	getter := GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		loads++
+		return []byte(db[key]), nil
+	})
+
+	// 自身为主节点，加载后异步写入副本节点
+	replica := &fakePeer{sets: make(chan *pb.SetRequest, 1)}
+	primary := NewGroup("replicate", 2<<10, getter)
+	primary.SetReplication(2)
+	primary.RegisterPeers(&replicaPicker{owners: []PeerGetter{nil, replica}})
+	if view, err := primary.Get(context.Background(), "Tom"); err != nil || view.String() != "630" || loads != 1 {
+		t.Fatalf("primary should load Tom, got %s, loads %d", view, loads)
+	}
+	select {
+	case in := <-replica.sets:
+		if in.GetKey() != "Tom" || string(in.GetValue()) != "630" {
+			t.Fatalf("unexpected replicated entry %v", in)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Tom should be pushed to replica")
+	}
+
+	// 主节点请求失败，从副本节点获取
+	failed := &fakePeer{err: fmt.Errorf("peer down"), sets: make(chan *pb.SetRequest, 1)}
+	failover := NewGroup("failover", 2<<10, getter)
+	failover.SetReplication(2)
+	failover.RegisterPeers(&replicaPicker{owners: []PeerGetter{failed, &fakePeer{value: "631"}}})
+	if view, err := failover.Get(context.Background(), "Tom"); err != nil || view.String() != "631" || loads != 1 {
+		t.Fatalf("Tom should be got from replica, got %s, loads %d", view, loads)
+	}
+
+	// 自身为副本节点，主节点请求失败时从本地加载，且不再写入其他节点
+	local := NewGroup("failover-local", 2<<10, getter)
+	local.SetReplication(2)
+	local.RegisterPeers(&replicaPicker{owners: []PeerGetter{failed, nil}})
+	if view, err := local.Get(context.Background(), "Tom"); err != nil || view.String() != "630" || loads != 2 {
+		t.Fatalf("replica should load Tom locally, got %s, loads %d", view, loads)
+	}
+	select {
+	case <-failed.sets:
+		t.Fatalf("replica should not push to primary")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// slowPeer 测试用远程节点，Get 在 delay 后才返回，期间调用方取消则返回 ctx.Err()
+type slowPeer struct {
+	fakePeer
+	delay     time.Duration
+	cancelled chan struct{}
+}
+
+func (s *slowPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	select {
+	case <-time.After(s.delay):
+		return s.fakePeer.Get(ctx, in, out)
+	case <-ctx.Done():
+		close(s.cancelled)
+		return ctx.Err()
+	}
+}
+
+// 测试对冲请求：远程节点超过延迟未返回时向副本节点或本地发起请求，采用先返回的结果并取消另一个
+func TestHedgedGet(t *testing.T) {
+	getter := GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	})
+
+	// 慢节点被对冲到本地数据源
+	slow := &slowPeer{fakePeer: fakePeer{value: "631"}, delay: time.Second, cancelled: make(chan struct{})}
+	local := NewGroup("hedge-local", 2<<10, getter)
+	local.SetHedgeDelay(10 * time.Millisecond)
+	local.RegisterPeers(&replicaPicker{owners: []PeerGetter{slow}})
+	if view, err := local.Get(context.Background(), "Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("hedged request should load Tom locally, got %s, %v", view, err)
+	}
+	select {
+	case <-slow.cancelled:
+	case <-time.After(time.Second):
+		t.Fatalf("slow request should be cancelled once the hedge wins")
+	}
+	if stats := local.HedgeStats(); stats != (HedgeStats{Fired: 1, Won: 1}) {
+		t.Fatalf("unexpected hedge stats %+v", stats)
+	}
+
+	// 慢节点被对冲到副本节点
+	slow = &slowPeer{fakePeer: fakePeer{value: "631"}, delay: time.Second, cancelled: make(chan struct{})}
+	replica := NewGroup("hedge-replica", 2<<10, getter)
+	replica.SetReplication(2)
+	replica.SetHedgeDelay(10 * time.Millisecond)
+	replica.RegisterPeers(&replicaPicker{owners: []PeerGetter{slow, &fakePeer{value: "632"}}})
+	if view, err := replica.Get(context.Background(), "Tom"); err != nil || view.String() != "632" {
+		t.Fatalf("hedged request should get Tom from replica, got %s, %v", view, err)
+	}
+	if stats := replica.HedgeStats(); stats != (HedgeStats{Fired: 1, Won: 1}) {
+		t.Fatalf("unexpected hedge stats %+v", stats)
+	}
+
+	// 节点在延迟内返回，不发出对冲请求
+	fast := NewGroup("hedge-fast", 2<<10, getter)
+	fast.SetHedgeDelay(time.Second)
+	fast.RegisterPeers(&replicaPicker{owners: []PeerGetter{&fakePeer{value: "631"}}})
+	if view, err := fast.Get(context.Background(), "Tom"); err != nil || view.String() != "631" {
+		t.Fatalf("Tom should be got from peer, got %s, %v", view, err)
+	}
+	if stats := fast.HedgeStats(); stats != (HedgeStats{}) {
+		t.Fatalf("hedge should not fire for a fast peer, got %+v", stats)
+	}
+}
+
+// 测试哈希环变动后，本地缓存中归属新节点的key迁移至新节点
+func TestHandoff(t *testing.T) {
+	bulks := make(chan *pb.BulkSetRequest, 100)
+	pool := NewHTTPPool("self", nil)
+	pool.newGetter = func(addr string) PeerGetter {
+		return &fakePeer{bulks: bulks}
+	}
+	gee := NewGroup("handoff", 2<<10, GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			return []byte(key), nil
+		}))
+	gee.RegisterPeers(pool)
+	pool.SetHandoffRate(1000)
+	pool.Set("self")
+
+	keys := make([]string, 20)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("key%d", i)
+		gee.Get(context.Background(), keys[i])
+	}
+
+	pool.Set("self", "peer")
+	moved := make(map[string]bool)
+	for _, key := range keys {
+		if _, ok := pool.PickPeer(key); ok {
+			moved[key] = true
+		}
+	}
+	if len(moved) == 0 {
+		t.Fatalf("some keys should belong to the new peer")
+	}
+
+	received := 0
+	for received < len(moved) {
+		select {
+		case in := <-bulks:
+			for _, e := range in.GetEntries() {
+				if !moved[e.GetKey()] || string(e.GetValue()) != e.GetKey() {
+					t.Fatalf("unexpected handoff entry %v", e)
+				}
+			}
+			received += len(in.GetEntries())
+		case <-time.After(2 * time.Second):
+			t.Fatalf("expect %d keys handed off, got %d", len(moved), received)
+		}
+	}
+
+	// 迁移成功的key从本地移除，其余key保留
+	deadline := time.Now().Add(time.Second)
+	for _, key := range keys {
+		for {
+			_, ok := gee.mainCache.get(key)
+			if ok != moved[key] {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("key %s cached locally: %v, moved: %v", key, ok, moved[key])
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// flakyPeer 测试用远程节点，前 failures 次 Get 返回错误，calls 记录 Get 次数
+type flakyPeer struct {
+	fakePeer
+	failures int32
+	calls    int32
+	failure  error // 失败时返回的错误，为 nil 时返回 503
+}
+
+func (f *flakyPeer) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	atomic.AddInt32(&f.calls, 1)
+	if atomic.AddInt32(&f.failures, -1) >= 0 {
+		if f.failure != nil {
+			return f.failure
+		}
+		return &statusError{code: http.StatusServiceUnavailable, status: "503 Service Unavailable"}
+	}
+	return f.fakePeer.Get(ctx, in, out)
+}
+
+// 测试请求策略：读请求失败后重试，连续失败后熔断，冷却后半开试探，试探成功后关闭
+func TestPolicyGetter(t *testing.T) {
+	peer := &flakyPeer{fakePeer: fakePeer{value: "630"}, failures: 2}
+	getter := NewPolicyGetter(peer, Policy{Retries: 2, Backoff: time.Millisecond, FailureThreshold: 3, Cooldown: 50 * time.Millisecond})
+	in := &pb.Request{Group: "scores", Key: "Tom"}
+
+	out := &pb.Response{}
+	if err := getter.Get(context.Background(), in, out); err != nil || string(out.Value) != "630" || peer.calls != 3 {
+		t.Fatalf("Get should succeed after 2 retries, got %v after %d calls", err, peer.calls)
+	}
+	if getter.State() != BreakerClosed {
+		t.Fatalf("breaker should stay closed after a success, got %v", getter.State())
+	}
+
+	// 连续失败 3 次后熔断，之后的请求不再发往节点
+	atomic.StoreInt32(&peer.failures, 100)
+	atomic.StoreInt32(&peer.calls, 0)
+	if err := getter.Get(context.Background(), in, &pb.Response{}); err == nil || peer.calls != 3 {
+		t.Fatalf("Get should fail after 3 attempts, got %v after %d calls", err, peer.calls)
+	}
+	if getter.State() != BreakerOpen {
+		t.Fatalf("breaker should open after 3 consecutive failures, got %v", getter.State())
+	}
+	if err := getter.Get(context.Background(), in, &pb.Response{}); !errors.Is(err, ErrBreakerOpen) || peer.calls != 3 {
+		t.Fatalf("open breaker should reject requests, got %v after %d calls", err, peer.calls)
+	}
+
+	// 冷却后半开，试探失败重新打开
+	time.Sleep(60 * time.Millisecond)
+	getter.policy.Retries = 0
+	if err := getter.Get(context.Background(), in, &pb.Response{}); err == nil || errors.Is(err, ErrBreakerOpen) || peer.calls != 4 {
+		t.Fatalf("half-open breaker should let one request through, got %v after %d calls", err, peer.calls)
+	}
+	if getter.State() != BreakerOpen {
+		t.Fatalf("failed probe should reopen breaker, got %v", getter.State())
+	}
+
+	// 冷却后试探成功，关闭熔断器
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&peer.failures, 0)
+	if err := getter.Get(context.Background(), in, &pb.Response{}); err != nil || getter.State() != BreakerClosed {
+		t.Fatalf("successful probe should close breaker, got %v, state %v", err, getter.State())
+	}
+}
+
+// 测试请求策略：节点数据源出错等应用层错误不重试，也不打开熔断器
+func TestPolicyGetterApplicationError(t *testing.T) {
+	peer := &flakyPeer{fakePeer: fakePeer{value: "630"}, failures: 100, failure: &statusError{code: http.StatusInternalServerError, status: "500 Internal Server Error"}}
+	getter := NewPolicyGetter(peer, Policy{Retries: 2, Backoff: time.Millisecond, FailureThreshold: 2, Cooldown: time.Minute})
+	in := &pb.Request{Group: "scores", Key: "Tom"}
+
+	for i := 1; i <= 5; i++ {
+		if err := getter.Get(context.Background(), in, &pb.Response{}); err == nil || errors.Is(err, ErrBreakerOpen) || peer.calls != int32(i) {
+			t.Fatalf("500 should be returned without retry, got %v after %d calls", err, peer.calls)
+		}
+	}
+	if getter.State() != BreakerClosed {
+		t.Fatalf("application errors should not open the breaker, got %v", getter.State())
+	}
+
+	// 节点不可用与应用层错误交替出现时，应用层错误重置连续失败计数
+	getter.policy.Retries = 0
+	for _, failure := range []error{nil, &statusError{code: http.StatusUnauthorized, status: "401 Unauthorized"}, nil} {
+		peer.failure = failure
+		getter.Get(context.Background(), in, &pb.Response{})
+	}
+	if getter.State() != BreakerClosed {
+		t.Fatalf("401 should reset consecutive failures, got %v", getter.State())
+	}
+}
+
+// 测试请求策略的超时，响应缓慢的节点不会拖住调用方
+func TestPolicyGetterTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+	getter := NewPolicyGetter(&httpGetter{baseURL: slow.URL + defaultBasePath}, Policy{Timeout: 20 * time.Millisecond})
+
+	start := time.Now()
+	if err := getter.Get(context.Background(), &pb.Request{Group: "scores", Key: "Tom"}, &pb.Response{}); err == nil {
+		t.Fatalf("Get from slow peer should time out")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("Get should give up after the timeout, took %v", elapsed)
+	}
+}
+
+// 测试 Group.GetMany 及通过 HTTPPool 批量获取
+func TestGetMany(t *testing.T) {
+	gee := NewGroup("many", 2<<10, GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist", key)
+		}))
+
+	keys := []string{"Tom", "unknown", "Jack", ""}
+	check := func(results []Result) {
+		if len(results) != len(keys) {
+			t.Fatalf("expect %d results, got %d", len(keys), len(results))
+		}
+		for i, res := range results {
+			if res.Key != keys[i] {
+				t.Fatalf("result %d should be %s, got %s", i, keys[i], res.Key)
+			}
+			if v, ok := db[res.Key]; ok {
+				if res.Err != nil || res.Value.String() != v {
+					t.Fatalf("%s should be %s, got %s (err %v)", res.Key, v, res.Value, res.Err)
+				}
+			} else if res.Err == nil {
+				t.Fatalf("%q should fail", res.Key)
+			}
+		}
+	}
+	check(gee.GetMany(context.Background(), keys))
+
+	srv := httptest.NewServer(NewHTTPPool("self", nil))
+	defer srv.Close()
+	results := []Result{{Key: "Tom"}, {Key: "unknown"}, {Key: "Jack"}, {Key: ""}}
+	gee.getManyFromPeer(context.Background(), &httpGetter{baseURL: srv.URL + defaultBasePath}, []int{0, 1, 2, 3}, results)
+	check(results)
+}
+
+// 测试负缓存：不存在的key在有效期内不再访问数据源，并能在节点间传递
+func TestNegativeCache(t *testing.T) {
+	loads := 0
+	gee := NewGroup("negative", 2<<10, GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}))
+	gee.SetNegativeTTL(100 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if _, err := gee.Get(context.Background(), "unknown"); err != ErrNotFound {
+			t.Fatalf("unknown should be ErrNotFound, got %v", err)
+		}
+	}
+	if loads != 1 {
+		t.Fatalf("unknown should be loaded once, loads %d", loads)
+	}
+
+	srv := httptest.NewServer(NewHTTPPool("self", nil))
+	defer srv.Close()
+	peer := &httpGetter{baseURL: srv.URL + defaultBasePath}
+	gee.removeLocally("unknown")
+	if _, err := gee.getFromPeer(context.Background(), peer, "unknown"); err != ErrNotFound || loads != 2 {
+		t.Fatalf("peer should report ErrNotFound, got %v, loads %d", err, loads)
+	}
+	results := []Result{{Key: "unknown"}}
+	gee.getManyFromPeer(context.Background(), peer, []int{0}, results)
+	if results[0].Err != ErrNotFound || loads != 2 {
+		t.Fatalf("batch should report ErrNotFound, got %v, loads %d", results[0].Err, loads)
+	}
+
+	// 返回给请求方的过期时间应为负缓存中记录的时间，而不是从响应时刻重新计算
+	stored, ok := gee.negCache.get("unknown")
+	if !ok {
+		t.Fatal("unknown should be in the negative cache")
+	}
+	time.Sleep(20 * time.Millisecond)
+	want := expireToUnixNano(stored.Expire())
+	if resp, err := gee.getResponse(context.Background(), "unknown"); err != nil || !resp.NotFound || resp.Expire != want {
+		t.Fatalf("response expire should be %d, got %v, %v", want, resp, err)
+	}
+	if batch := gee.getManyResponse(context.Background(), []string{"unknown"}); !batch.Results[0].NotFound || batch.Results[0].Expire != want {
+		t.Fatalf("batch expire should be %d, got %v", want, batch.Results[0])
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	if _, err := gee.Get(context.Background(), "unknown"); err != ErrNotFound || loads != 3 {
+		t.Fatalf("expired negative entry should be reloaded, loads %d", loads)
+	}
+}
+
+// 测试通过 GRPCPool 获取、批量获取、写入、移除远程节点缓存
+func TestGRPCPool(t *testing.T) {
+	loads := 0
+	gee := NewGroup("grpc", 2<<10, GetterFunc(
+		func(ctx context.Context, key string) ([]byte, error) {
+			loads++
+			if v, ok := db[key]; ok {
+				return []byte(v), nil
+			}
+			return nil, fmt.Errorf("%s not exist: %w", key, ErrNotFound)
+		}))
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	pb.RegisterGroupCacheServer(server, NewGRPCPool("self", nil))
+	go server.Serve(lis)
+	defer server.Stop()
+
+	ctx := context.Background()
+	peer := newGRPCGetter(lis.Addr().String())
+	defer peer.(*grpcGetter).Close()
+
+	if view, err := gee.getFromPeer(ctx, peer, "Tom"); err != nil || view.String() != "630" {
+		t.Fatalf("Tom should be 630, got %s (err %v)", view, err)
+	}
+	if _, err := gee.getFromPeer(ctx, peer, "unknown"); err != ErrNotFound {
+		t.Fatalf("unknown should be ErrNotFound, got %v", err)
+	}
+	results := []Result{{Key: "Tom"}, {Key: "Jack"}}
+	gee.getManyFromPeer(ctx, peer, []int{0, 1}, results)
+	if results[0].Value.String() != "630" || results[1].Value.String() != "589" {
+		t.Fatalf("GetMany over grpc failed: %v", results)
+	}
+	if err := gee.setToPeer(ctx, peer, "Tom", ByteView{b: []byte("700")}); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := gee.Get(ctx, "Tom"); view.String() != "700" {
+		t.Fatalf("Tom set over grpc should be 700, got %s", view)
+	}
+	if err := gee.removeFromPeer(ctx, peer, "Tom"); err != nil {
+		t.Fatal(err)
+	}
+	if view, _ := gee.Get(ctx, "Tom"); view.String() != "630" || loads != 4 {
+		t.Fatalf("Tom removed over grpc should be reloaded, got %s, loads %d", view, loads)
+	}
+}
+
+// 测试使用固定节点列表的 HTTPPool 选择节点
+func TestHTTPPoolStatic(t *testing.T) {
+	peers := []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"}
+
+	viaWork := NewHTTPPool(peers[0], discovery.NewStatic(peers...))
+	if err := viaWork.Work(); err != nil {
+		t.Fatal(err)
+	}
+	viaSet := NewHTTPPool(peers[0], nil)
+	viaSet.Set(peers...)
+
+	if len(viaWork.AllPeers()) != 2 || len(viaSet.AllPeers()) != 2 {
+		t.Fatalf("AllPeers should exclude self")
+	}
+	for _, key := range []string{"Tom", "Jack", "Sam", "Tang", "Lbj", "Liu"} {
+		p1, ok1 := viaWork.PickPeer(key)
+		p2, ok2 := viaSet.PickPeer(key)
+		if ok1 != ok2 || (ok1 && p1.(*loadGetter).addr != p2.(*loadGetter).addr) {
+			t.Fatalf("Work and Set should pick the same peer for %s", key)
+		}
+	}
+
+	viaSet.Set(peers[0])
+	if _, ok := viaSet.PickPeer("Tom"); ok || len(viaSet.AllPeers()) != 0 {
+		t.Fatalf("Set should replace the peer list")
+	}
+}
+
+// 测试 HTTPPool 使用其他节点选择算法
+func TestHTTPPoolSelector(t *testing.T) {
+	peers := []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"}
+	pool := NewHTTPPool("self", nil)
+	if err := pool.SetSelector("unknown"); err == nil {
+		t.Fatalf("unknown selector should return error")
+	}
+	pool.Set(peers[:2]...)
+	pool.Set(peers...)
+	if len(pool.RingStatus().Moves) == 0 {
+		t.Fatalf("adding peers to the ring should record moves")
+	}
+	if err := pool.SetSelector(consistenthash.AlgoMaglev); err != nil {
+		t.Fatal(err)
+	}
+	if status := pool.RingStatus(); status.Algorithm != consistenthash.AlgoMaglev || status.Moves != nil {
+		t.Fatalf("switching selector should clear moves of the old ring, got %+v", status)
+	}
+	selector := pool.peers
+	if err := pool.SetSelector(consistenthash.AlgoMaglev); err != nil || pool.peers != selector {
+		t.Fatalf("setting the same selector should not rebuild it")
+	}
+
+	want := consistenthash.NewMaglev(0)
+	want.Add(peers...)
+	for _, key := range []string{"Tom", "Jack", "Sam", "Tang", "Lbj", "Liu"} {
+		peer, ok := pool.PickPeer(key)
+		if !ok || peer.(*loadGetter).addr != want.Get(key) {
+			t.Fatalf("%s should be picked to %s", key, want.Get(key))
+		}
+	}
+}
+
+// 测试 HTTPPool 管理接口返回的哈希环状态
+func TestHTTPPoolRing(t *testing.T) {
+	peers := []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"}
+	pool := NewHTTPPool("self", nil)
+	pool.Set(peers[:2]...)
+	pool.Set(peers...)
+
+	srv := httptest.NewServer(pool)
+	defer srv.Close()
+	res, err := http.Get(srv.URL + defaultBasePath + ringPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var status RingStatus
+	if err = json.NewDecoder(res.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(status.Members, peers) || len(status.Nodes) != 3 {
+		t.Fatalf("unexpected ring status %+v", status)
+	}
+	var share float64
+	for _, n := range status.Nodes {
+		share += n.Share
+	}
+	if share < 0.999 || share > 1.001 {
+		t.Fatalf("shares should sum to 1, got %v", share)
+	}
+	// 新增的节点只从原有节点接管区间
+	if len(status.Moves) == 0 {
+		t.Fatalf("adding a peer should move some ranges")
+	}
+	for _, move := range status.Moves {
+		if move.To != peers[2] {
+			t.Fatalf("ranges should only move to the new peer, got %+v", move)
+		}
+	}
+}
+
+// 测试 HTTPPool 健康检查，不健康的节点暂时移出，恢复后重新加入
+func TestHTTPPoolHealth(t *testing.T) {
+	NewGroup("health", 2<<10, GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		return []byte(key), nil
+	}))
+	var wedged int32 = 1
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&wedged) == 1 {
+			http.Error(w, "wedged", http.StatusServiceUnavailable)
+			return
+		}
+		NewHTTPPool("bad", nil).ServeHTTP(w, r)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(NewHTTPPool("good", nil))
+	defer good.Close()
+	badAddr, goodAddr := strings.TrimPrefix(bad.URL, "http://"), strings.TrimPrefix(good.URL, "http://")
+
+	pool := NewHTTPPool("self", nil)
+	pool.Set(badAddr, goodAddr)
+	var key string // 所属节点为 bad 的key
+	for i := 0; key == ""; i++ {
+		if peer, _ := pool.PickPeer(strconv.Itoa(i)); peer.(*loadGetter).addr == badAddr {
+			key = strconv.Itoa(i)
+		}
+	}
+	pick := func() string {
+		peer, _ := pool.PickPeer(key)
+		return peer.(*loadGetter).addr
+	}
+	get := func(peer PeerGetter) error {
+		return peer.Get(context.Background(), &pb.Request{Group: "health", Key: key}, &pb.Response{})
+	}
+	down := func() bool {
+		h := pool.peerHealth(badAddr)
+		return h != nil && atomic.LoadInt32(&h.down) == 1
+	}
+
+	// 被动：请求连续失败 2 次后移出
+	pool.SetHealthCheck(0, 2)
+	peer, _ := pool.PickPeer(key)
+	for i := 0; i < 2; i++ {
+		if err := get(peer); err == nil {
+			t.Fatalf("request to wedged peer should fail")
+		}
+	}
+	if pick() != goodAddr {
+		t.Fatalf("unhealthy peer should be ejected from the pick path")
+	}
+
+	// 被动：冷却后只放行一个试探请求，试探失败继续移出，试探成功后恢复
+	atomic.StoreInt64(&pool.healthCooldown, int64(20*time.Millisecond))
+	for _, recovered := range []bool{false, true} {
+		time.Sleep(30 * time.Millisecond)
+		if recovered {
+			atomic.StoreInt32(&wedged, 0)
+		}
+		trial, _ := pool.PickPeer(key)
+		if trial.(*loadGetter).addr != badAddr || pick() != goodAddr {
+			t.Fatalf("ejected peer should get exactly one trial request after the cooldown")
+		}
+		if err := get(trial); (err == nil) != recovered || down() == recovered {
+			t.Fatalf("trial request (recovered=%v) got %v, down=%v", recovered, err, down())
+		}
+	}
+	if pick() != badAddr {
+		t.Fatalf("peer should be restored after a successful trial request")
+	}
+
+	// 主动：探测连续失败后移出
+	atomic.StoreInt32(&wedged, 1)
+	pool.SetHealthCheck(10*time.Millisecond, 2)
+	deadline := time.Now().Add(2 * time.Second)
+	for !down() {
+		if time.Now().After(deadline) {
+			t.Fatalf("peer failing probes should be ejected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 主动：探测成功后恢复
+	atomic.StoreInt32(&wedged, 0)
+	deadline = time.Now().Add(2 * time.Second)
+	for down() {
+		if time.Now().After(deadline) {
+			t.Fatalf("recovered peer should be restored")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	pool.SetHealthCheck(0, 0)
+}
+
+// 测试只有说明节点不可用的错误计入健康检查，应用层错误及熔断器拒绝的请求不会使节点移出
+func TestPeerFailure(t *testing.T) {
+	peer := &fakePeer{}
+	pool := newPeerPool("self", nil, func(addr string) PeerGetter { return peer })
+	pool.Set("peer")
+	pool.SetHealthCheck(0, 1)
+	getter := pool.getters["peer"]
+
+	for _, err := range []error{
+		&statusError{code: http.StatusInternalServerError, status: "500 Internal Server Error"},
+		status.Error(codes.Unknown, "db error"),
+		ErrBreakerOpen,
+	} {
+		peer.err = err
+		getter.Get(context.Background(), &pb.Request{Group: "scores", Key: "Tom"}, &pb.Response{})
+		if pool.isDown("peer") {
+			t.Fatalf("%v should not eject the peer", err)
+		}
+	}
+
+	for _, err := range []error{
+		&statusError{code: http.StatusServiceUnavailable, status: "503 Service Unavailable"},
+		status.Error(codes.Unavailable, "connection refused"),
+		&url.Error{Op: "Get", URL: "http://peer", Err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}},
+		context.DeadlineExceeded,
+	} {
+		pool.forgetHealth("peer")
+		peer.err = err
+		getter.Get(context.Background(), &pb.Request{Group: "scores", Key: "Tom"}, &pb.Response{})
+		if !pool.isDown("peer") {
+			t.Fatalf("%v should eject the peer", err)
+		}
+	}
+}
+
+// roundTripperFunc 测试用 http.RoundTripper
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// 测试 HTTPPoolOptions：Transport 钩子包装节点间请求，开启 H2C 后使用 HTTP/2 通讯
+func TestHTTPPoolOptions(t *testing.T) {
+	for _, h2c := range []bool{false, true} {
+		server := NewHTTPPoolOpts("server", nil, &HTTPPoolOptions{H2C: h2c})
+		peer := httptest.NewServer(server.Handler())
+		peerAddr := strings.TrimPrefix(peer.URL, "http://")
+
+		var protos []int
+		pool := NewHTTPPoolOpts("self", nil, &HTTPPoolOptions{
+			H2C: h2c,
+			Transport: func(next http.RoundTripper) http.RoundTripper {
+				return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+					res, err := next.RoundTrip(req)
+					if err == nil {
+						protos = append(protos, res.ProtoMajor)
+					}
+					return res, err
+				})
+			},
+		})
+		pool.Set(peerAddr)
+		g, _ := pool.PickPeer("Tom")
+		if err := g.(*loadGetter).PeerGetter.(*httpGetter).Health(context.Background()); err != nil {
+			t.Fatalf("h2c=%v: health check failed: %v", h2c, err)
+		}
+		want := 1
+		if h2c {
+			want = 2
+		}
+		if !reflect.DeepEqual(protos, []int{want}) {
+			t.Fatalf("h2c=%v: request should go through the hook over HTTP/%d, got %v", h2c, want, protos)
+		}
+		peer.Close()
+	}
+
+	// 替换为假实现，不发出网络请求
+	pool := NewHTTPPoolOpts("self", nil, &HTTPPoolOptions{
+		RoundTripper: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			body, _ := proto.Marshal(&pb.Response{Value: []byte(req.URL.Host)})
+			return &http.Response{StatusCode: http.StatusOK, Status: "200 OK", Body: ioutil.NopCloser(bytes.NewReader(body))}, nil
+		}),
+	})
+	pool.Set("fake:1")
+	peer, _ := pool.PickPeer("Tom")
+	out := &pb.Response{}
+	if err := peer.Get(context.Background(), &pb.Request{Group: "scores", Key: "Tom"}, out); err != nil || string(out.Value) != "fake:1" {
+		t.Fatalf("request should be served by the fake round tripper, got %s, %v", out.Value, err)
+	}
+}
+
+// testCA 测试时生成的自签名 CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "geecache test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+// issue 签发 hosts（默认为 127.0.0.1）的节点证书，可同时用作服务端和客户端证书，返回 PEM 编码的证书和私钥
+func (ca *testCA) issue(t *testing.T, serial int64, hosts ...string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "geecache peer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	if len(hosts) == 0 {
+		hosts = []string{"127.0.0.1"}
+	}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// writeFile 写入文件，并将修改时间设为 mtime，避免文件系统时间精度导致更新未被察觉
+func writeFile(t *testing.T, name string, data []byte, mtime time.Time) {
+	if err := ioutil.WriteFile(name, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 测试节点间双向 TLS：集群成员可以访问，没有客户端证书的请求被拒绝，证书文件更新后自动重新加载
+func TestHTTPPoolTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile, caFile := filepath.Join(dir, "peer.pem"), filepath.Join(dir, "peer.key"), filepath.Join(dir, "ca.pem")
+	ca := newTestCA(t)
+	certPEM, keyPEM := ca.issue(t, 2)
+	mtime := time.Now()
+	writeFile(t, certFile, certPEM, mtime)
+	writeFile(t, keyFile, keyPEM, mtime)
+	writeFile(t, caFile, ca.pem, mtime)
+
+	if _, err := NewPeerTLS(certFile, keyFile, "", true); err == nil {
+		t.Fatalf("mutual TLS without a CA file should be rejected")
+	}
+	peerTLS, err := NewPeerTLS(certFile, keyFile, caFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewHTTPPoolOpts("server", nil, &HTTPPoolOptions{TLS: peerTLS})
+	peer := httptest.NewUnstartedServer(server.Handler())
+	peer.TLS = peerTLS.ServerConfig()
+	peer.StartTLS()
+	defer peer.Close()
+	peerAddr := strings.TrimPrefix(peer.URL, "https://")
+
+	// 集群成员通过 https 访问
+	pool := NewHTTPPoolOpts("self", nil, &HTTPPoolOptions{TLS: peerTLS})
+	pool.Set(peerAddr)
+	g, _ := pool.PickPeer("Tom")
+	getter := g.(*loadGetter).PeerGetter.(*httpGetter)
+	if !strings.HasPrefix(getter.baseURL, "https://") {
+		t.Fatalf("peer should be dialed over https, got %s", getter.baseURL)
+	}
+	if err := getter.Health(context.Background()); err != nil {
+		t.Fatalf("member should pass mutual TLS: %v", err)
+	}
+
+	// 同一 CA 为其他地址签发的证书不能冒充该节点
+	for i, host := range []string{"127.0.0.2", "other-peer"} {
+		impostorCert, impostorKey := filepath.Join(dir, host+".pem"), filepath.Join(dir, host+".key")
+		certPEM, keyPEM := ca.issue(t, int64(10+i), host)
+		writeFile(t, impostorCert, certPEM, mtime)
+		writeFile(t, impostorKey, keyPEM, mtime)
+		impostorTLS, err := NewPeerTLS(impostorCert, impostorKey, caFile, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		impostor := httptest.NewUnstartedServer(server.Handler())
+		impostor.TLS = impostorTLS.ServerConfig()
+		impostor.StartTLS()
+		pool.Set(strings.TrimPrefix(impostor.URL, "https://"))
+		g, _ := pool.PickPeer("Tom")
+		err = g.(*loadGetter).PeerGetter.(*httpGetter).Health(context.Background())
+		impostor.Close()
+		if err == nil {
+			t.Fatalf("certificate issued for %s should not be accepted for 127.0.0.1", host)
+		}
+	}
+
+	// ClientConfig 无法得知以 IP 访问的对端地址，拒绝连接
+	if conn, err := tls.Dial("tcp", peerAddr, peerTLS.ClientConfig()); err == nil {
+		conn.Close()
+		t.Fatalf("ClientConfig without a ServerName should not accept an IP peer")
+	}
+	if conn, err := peerTLS.DialTLSContext(context.Background(), "tcp", peerAddr); err != nil {
+		t.Fatalf("DialTLSContext should verify the dialed IP: %v", err)
+	} else {
+		conn.Close()
+	}
+
+	// 信任 CA 但没有客户端证书
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(ca.pem)
+	outsider := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	if res, err := outsider.Get(peer.URL + defaultBasePath + healthPath); err == nil {
+		res.Body.Close()
+		t.Fatalf("request without a client certificate should be rejected")
+	}
+
+	// 更新证书文件后，新的连接使用新证书
+	certPEM, keyPEM = ca.issue(t, 3)
+	mtime = mtime.Add(time.Minute)
+	writeFile(t, certFile, certPEM, mtime)
+	writeFile(t, keyFile, keyPEM, mtime)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := tls.Dial("tcp", peerAddr, &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("dial with the new certificate failed: %v", err)
+	}
+	defer conn.Close()
+	if serial := conn.ConnectionState().PeerCertificates[0].SerialNumber.Int64(); serial != 3 {
+		t.Fatalf("server should present the reloaded certificate, got serial %d", serial)
+	}
+}
+
+// 测试 HMACAuth：签名可通过校验，重放、过期、密钥错误的请求被拒绝，更换密钥后旧密钥在短时间内仍有效
+func TestHMACAuth(t *testing.T) {
+	newRequest := func() *http.Request {
+		return httptest.NewRequest(http.MethodGet, "http://peer"+defaultBasePath+"scores/Tom", nil)
+	}
+	client, server := NewHMACAuth("s3cret"), NewHMACAuth("s3cret")
+
+	req := newRequest()
+	if err := client.Sign(req); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Verify(req); err != nil {
+		t.Fatalf("signed request should pass: %v", err)
+	}
+	if err := server.Verify(req); err == nil {
+		t.Fatalf("replayed request should be rejected")
+	}
+	if err := server.Verify(newRequest()); err == nil {
+		t.Fatalf("unsigned request should be rejected")
+	}
+
+	// 篡改路径后签名失效
+	req = newRequest()
+	client.Sign(req)
+	req.URL.Path = defaultBasePath + "scores/Jack"
+	if err := server.Verify(req); err == nil {
+		t.Fatalf("request with a tampered path should be rejected")
+	}
+
+	// 篡改请求体或查询参数后签名失效
+	newPut := func(body string) *http.Request {
+		req, _ := http.NewRequest(http.MethodPut, "http://peer"+defaultBasePath+"scores/Tom", strings.NewReader(body))
+		return req
+	}
+	req = newPut("630")
+	client.Sign(req)
+	tampered := httptest.NewRequest(http.MethodPut, "http://peer"+defaultBasePath+"scores/Tom", strings.NewReader("0"))
+	tampered.Header = req.Header.Clone()
+	if err := server.Verify(tampered); err == nil {
+		t.Fatalf("request with a tampered body should be rejected")
+	}
+	untouched := httptest.NewRequest(http.MethodPut, "http://peer"+defaultBasePath+"scores/Tom", strings.NewReader("630"))
+	untouched.Header = req.Header.Clone()
+	if err := server.Verify(untouched); err != nil {
+		t.Fatalf("signed request with a body should pass: %v", err)
+	}
+	if body, _ := ioutil.ReadAll(untouched.Body); string(body) != "630" {
+		t.Fatalf("Verify should restore the request body, got %q", body)
+	}
+	req = newRequest()
+	client.Sign(req)
+	req.URL.RawQuery = "x=1"
+	if err := server.Verify(req); err == nil {
+		t.Fatalf("request with a tampered query should be rejected")
+	}
+
+	// 时间戳超出允许的偏差
+	req = newRequest()
+	client.Sign(req)
+	server.skew = -time.Second
+	if err := server.Verify(req); err == nil {
+		t.Fatalf("stale request should be rejected")
+	}
+	server.skew = defaultAuthSkew
+
+	// 更换密钥后仍接受旧密钥的签名，密钥不一致的节点被拒绝
+	server.SetSecret("n3w")
+	req = newRequest()
+	client.Sign(req)
+	if err := server.Verify(req); err != nil {
+		t.Fatalf("request signed with the previous secret should pass during rotation: %v", err)
+	}
+	req = newRequest()
+	NewHMACAuth("wrong").Sign(req)
+	if err := server.Verify(req); err == nil {
+		t.Fatalf("request signed with a wrong secret should be rejected")
+	}
+}
+
+// 测试 HTTPPool 从集群配置中获取共享密钥，httpGetter 自动签名，未签名的请求被拒绝
+func TestHTTPPoolAuth(t *testing.T) {
+	NewGroup("auth", 2<<10, GetterFunc(func(ctx context.Context, key string) ([]byte, error) {
+		return []byte(db[key]), nil
+	}))
+	server := NewHTTPPool("server", nil)
+	peer := httptest.NewServer(server)
+	defer peer.Close()
+	peerAddr := strings.TrimPrefix(peer.URL, "http://")
+
+	d := discovery.NewStatic(peerAddr)
+	d.SetConfig(discovery.AuthSecret, "s3cret")
+	server.discovery = d
+	if err := server.Work(); err != nil {
+		t.Fatal(err)
+	}
+	pool := NewHTTPPool("self", d)
+	if err := pool.Work(); err != nil {
+		t.Fatal(err)
+	}
+
+	g, _ := pool.PickPeer("Tom")
+	out := &pb.Response{}
+	if err := g.Get(context.Background(), &pb.Request{Group: "auth", Key: "Tom"}, out); err != nil || string(out.Value) != "630" {
+		t.Fatalf("signed request should be served, got %s, %v", out.Value, err)
+	}
+
+	res, err := http.Get(peer.URL + defaultBasePath + "auth/Tom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned request should be rejected, got %s", res.Status)
+	}
+
+	// 健康检查无需认证
+	res, err = http.Get(peer.URL + defaultBasePath + healthPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("health check should not require auth, got %s", res.Status)
+	}
+}
+
+// 测试 HTTPPool 开启有界负载后，节点负载达到上限时选择下一个节点
+func TestHTTPPoolBoundedLoad(t *testing.T) {
+	peers := []string{"127.0.0.1:8001", "127.0.0.1:8002", "127.0.0.1:8003"}
+	pool := NewHTTPPool("self", nil)
+	pool.Set(peers...)
+	pool.SetLoadFactor(1.25)
+
+	peer, ok := pool.PickPeer("Tom")
+	if !ok {
+		t.Fatalf("Tom should be picked to a peer")
+	}
+	owner := peer.(*loadGetter).addr
+	pool.startLoad(owner)
+	pool.startLoad(owner)
+	if peer, _ := pool.PickPeer("Tom"); peer.(*loadGetter).addr == owner {
+		t.Fatalf("overloaded %s should not be picked", owner)
+	}
+	pool.finishLoad(owner)
+	pool.finishLoad(owner)
+	if peer, _ := pool.PickPeer("Tom"); peer.(*loadGetter).addr != owner {
+		t.Fatalf("%s should be picked again after finishing requests", owner)
+	}
+}
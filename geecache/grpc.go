@@ -0,0 +1,192 @@
+package geecache
+
+import (
+	"context"
+	"fmt"
+	"geecache/discovery"
+	pb "geecache/geecachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"log"
+)
+
+// GRPCPool 承载节点间 gRPC 通信的服务端，实现 pb.GroupCacheServer，可替代 HTTPPool 使用
+// 与 HTTPPool 共用同一套哈希环及etcd集群监听逻辑
+type GRPCPool struct {
+	*peerPool
+	pb.UnimplementedGroupCacheServer
+}
+
+// NewGRPCPool 构造 GRPCPool，调用 Work 后通过服务发现 d 维护集群节点，d 为 nil 时需调用 Set 设置固定的节点列表
+func NewGRPCPool(self string, d discovery.Discovery) *GRPCPool {
+	return &GRPCPool{peerPool: newPeerPool(self, d, newGRPCGetter)}
+}
+
+// Get 实现 pb.GroupCacheServer，获取key对应的缓存，key不存在时 pb.Response.NotFound 为 true
+func (p *GRPCPool) Get(ctx context.Context, in *pb.Request) (*pb.Response, error) {
+	p.Log("Get %s/%s", in.GetGroup(), in.GetKey())
+	group, err := p.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	return group.getResponse(ctx, in.GetKey())
+}
+
+// GetMany 实现 pb.GroupCacheServer，批量获取key，单个key的错误记录在对应结果中
+func (p *GRPCPool) GetMany(ctx context.Context, in *pb.BatchRequest) (*pb.BatchResponse, error) {
+	p.Log("GetMany %s", in.GetGroup())
+	group, err := p.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	return group.getManyResponse(ctx, in.GetKeys()), nil
+}
+
+// Remove 实现 pb.GroupCacheServer，移除该节点上key对应的缓存，只移除本地，不再向其他节点广播
+func (p *GRPCPool) Remove(ctx context.Context, in *pb.Request) (*pb.Empty, error) {
+	p.Log("Remove %s/%s", in.GetGroup(), in.GetKey())
+	group, err := p.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.removeLocally(in.GetKey())
+	return &pb.Empty{}, nil
+}
+
+// Set 实现 pb.GroupCacheServer，将kv写入该节点的缓存
+func (p *GRPCPool) Set(ctx context.Context, in *pb.SetRequest) (*pb.Empty, error) {
+	p.Log("Set %s/%s", in.GetGroup(), in.GetKey())
+	group, err := p.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.setLocally(in.GetKey(), ByteView{b: in.GetValue(), e: expireFromUnixNano(in.GetExpire())})
+	return &pb.Empty{}, nil
+}
+
+// SetMany 实现 pb.GroupCacheServer，将多个kv批量写入该节点的缓存
+func (p *GRPCPool) SetMany(ctx context.Context, in *pb.BulkSetRequest) (*pb.Empty, error) {
+	p.Log("SetMany %s %d entries", in.GetGroup(), len(in.GetEntries()))
+	group, err := p.group(in.GetGroup())
+	if err != nil {
+		return nil, err
+	}
+	group.setManyLocally(in.GetEntries())
+	return &pb.Empty{}, nil
+}
+
+// group 返回对应name的命名空间，不存在时返回 codes.NotFound 错误
+func (p *GRPCPool) group(name string) (*Group, error) {
+	group := GetGroup(name)
+	if group == nil {
+		return nil, status.Error(codes.NotFound, "no such group: "+name)
+	}
+	return group, nil
+}
+
+var _ PeerPicker = (*GRPCPool)(nil)
+var _ pb.GroupCacheServer = (*GRPCPool)(nil)
+
+// grpcGetter 缓存服务gRPC客户端，实现 PeerGetter 接口
+type grpcGetter struct {
+	conn   *grpc.ClientConn
+	client pb.GroupCacheClient
+	err    error // 拨号失败的原因，不为 nil 时所有请求直接返回该错误
+}
+
+// newGRPCGetter 创建远程节点的gRPC客户端，连接在首次请求时建立
+func newGRPCGetter(addr string) PeerGetter {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil { //非阻塞拨号只会因地址、参数错误失败
+		log.Println("[GeeCache] Failed to dial peer", addr, err)
+		return &grpcGetter{err: err}
+	}
+	return &grpcGetter{conn: conn, client: pb.NewGroupCacheClient(conn)}
+}
+
+func (g *grpcGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	if g.err != nil {
+		return g.err
+	}
+	res, err := g.client.Get(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	return nil
+}
+
+// GetMany 批量获取远程节点上的key
+func (g *grpcGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	if g.err != nil {
+		return g.err
+	}
+	res, err := g.client.GetMany(ctx, in)
+	if err != nil {
+		return err
+	}
+	proto.Merge(out, res)
+	return nil
+}
+
+// Remove 通知远程节点移除key
+func (g *grpcGetter) Remove(ctx context.Context, in *pb.Request) error {
+	if g.err != nil {
+		return g.err
+	}
+	_, err := g.client.Remove(ctx, in)
+	return err
+}
+
+// Set 将kv写入远程节点
+func (g *grpcGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	if g.err != nil {
+		return g.err
+	}
+	_, err := g.client.Set(ctx, in)
+	return err
+}
+
+// SetMany 将多个kv批量写入远程节点
+func (g *grpcGetter) SetMany(ctx context.Context, in *pb.BulkSetRequest) error {
+	if g.err != nil {
+		return g.err
+	}
+	_, err := g.client.SetMany(ctx, in)
+	return err
+}
+
+// Health 探测远程节点是否健康，连接空闲时触发重连，等待至连接就绪或失败
+func (g *grpcGetter) Health(ctx context.Context) error {
+	if g.err != nil {
+		return g.err
+	}
+	g.conn.Connect()
+	for {
+		state := g.conn.GetState()
+		switch state {
+		case connectivity.Ready:
+			return nil
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			return fmt.Errorf("connection %v", state)
+		}
+		if !g.conn.WaitForStateChange(ctx, state) {
+			return ctx.Err()
+		}
+	}
+}
+
+// Close 关闭与远程节点的连接，节点移出集群时调用
+func (g *grpcGetter) Close() error {
+	if g.conn == nil {
+		return nil
+	}
+	return g.conn.Close()
+}
+
+// 判断 grpcGetter 是否实现 PeerGetter 接口
+var _ PeerGetter = (*grpcGetter)(nil)
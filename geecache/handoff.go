@@ -0,0 +1,133 @@
+package geecache
+
+import (
+	"context"
+	pb "geecache/geecachepb"
+	"log"
+	"time"
+)
+
+const handoffBatch = 100 // 迁移key时每次批量写入的条目数
+
+// SetHandoffRate 开启哈希环变动后的key迁移，rate 为每秒最多迁移的条目数，<= 0 表示不迁移
+// 节点加入或离开集群后，本地缓存中所属节点已不包含自身的key会批量写入新的所属节点，写入成功后从本地移除，
+// 使新节点不必从零开始预热
+func (p *peerPool) SetHandoffRate(rate int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handoffRate = rate
+	if rate <= 0 && p.handoffCancel != nil {
+		p.handoffCancel()
+		p.handoffCancel = nil
+	}
+}
+
+// startHandoff 取消进行中的迁移，按变动后的哈希环重新迁移，调用方需持有 p.mu
+func (p *peerPool) startHandoff() {
+	if p.handoffRate <= 0 {
+		return
+	}
+	if p.handoffCancel != nil {
+		p.handoffCancel()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	p.handoffCancel = cancel
+	go p.handoff(ctx, &rateLimiter{rate: p.handoffRate, start: time.Now()})
+}
+
+// handoff 迁移使用该节点池选择节点的所有 Group 中归属已变化的key
+func (p *peerPool) handoff(ctx context.Context, limiter *rateLimiter) {
+	mu.RLock()
+	var owned []*Group
+	for _, g := range groups {
+		if holder, ok := g.peers.(interface{ pool() *peerPool }); ok && holder.pool() == p {
+			owned = append(owned, g)
+		}
+	}
+	mu.RUnlock()
+
+	for _, g := range owned {
+		if moved := g.handoff(ctx, limiter); moved > 0 {
+			p.Log("handoff %d keys of group %s to new owners", moved, g.name)
+		}
+	}
+}
+
+// pool 返回节点池自身，用于判断 Group 是否使用该节点池
+func (p *peerPool) pool() *peerPool {
+	return p
+}
+
+// handoff 将本地缓存中所属节点已不包含自身的key批量写入新的主节点，写入成功后从本地移除，返回迁移的条目数
+// 写入某个节点失败时放弃迁移该节点的剩余key，这些key由新节点在访问时自行加载
+func (g *Group) handoff(ctx context.Context, limiter *rateLimiter) int {
+	batches := make(map[PeerGetter][]*pb.BulkEntry)
+	for _, e := range g.mainCache.entries() {
+		owners := g.pickOwners(e.key)
+		if containsSelf(owners) {
+			continue
+		}
+		batches[owners[0]] = append(batches[owners[0]], &pb.BulkEntry{
+			Key:    e.key,
+			Value:  e.value.b,
+			Expire: expireToUnixNano(e.value.Expire()),
+		})
+	}
+
+	moved := 0
+	for peer, entries := range batches {
+		for len(entries) > 0 {
+			n := handoffBatch
+			if n > len(entries) {
+				n = len(entries)
+			}
+			if err := limiter.wait(ctx, n); err != nil { //哈希环再次变动，已开始新的迁移
+				return moved
+			}
+			if err := peer.SetMany(ctx, &pb.BulkSetRequest{Group: g.name, Entries: entries[:n]}); err != nil {
+				log.Println("[GeeCache] Failed to hand off keys to peer", err)
+				break
+			}
+			for _, e := range entries[:n] {
+				g.mainCache.remove(e.GetKey())
+			}
+			moved += n
+			entries = entries[n:]
+		}
+	}
+	return moved
+}
+
+// containsSelf 判断 pickOwners 返回的所属节点中是否包含自身
+func containsSelf(owners []PeerGetter) bool {
+	for _, peer := range owners {
+		if peer == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimiter 按固定速率放行，限制迁移对网络及新节点的压力
+type rateLimiter struct {
+	rate  int       // 每秒放行的条目数
+	start time.Time // 开始时间
+	sent  int       // 已放行的条目数
+}
+
+// wait 等待至可以放行 n 个条目，ctx 取消时返回错误
+func (l *rateLimiter) wait(ctx context.Context, n int) error {
+	delay := time.Until(l.start.Add(time.Duration(l.sent) * time.Second / time.Duration(l.rate)))
+	l.sent += n
+	if delay <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,214 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	healthPath            = "_health"       // 健康检查接口，GET /<basepath>/_health 返回 200
+	defaultHealthCooldown = 5 * time.Second // 未开启主动探测时，节点移出后多久放行一个试探请求
+)
+
+// healthChecker 可选接口，节点客户端实现该接口时，peerPool 会定期主动探测节点是否健康
+type healthChecker interface {
+	Health(ctx context.Context) error
+}
+
+// peerHealth 节点健康状态，字段均为原子操作
+type peerHealth struct {
+	failures int32 // 连续失败次数
+	down     int32 // 1 表示已移出选择节点的路径
+	since    int64 // 移出或上次放行试探请求的时间，UnixNano
+}
+
+// SetHealthCheck 开启节点健康检查，threshold <= 0 表示不检查
+// 节点请求（被动）或探测（主动）连续失败 threshold 次后暂时不再被 PickPeer 选中，key 由哈希环上的下一个健康节点负责，
+// 之后探测或请求成功即恢复。interval > 0 时每隔 interval 主动探测所有节点；
+// 移出的节点每隔冷却时间（interval，未开启主动探测时为 defaultHealthCooldown）放行一个试探请求，使只有被动检查或不支持探测的节点也能恢复
+func (p *peerPool) SetHealthCheck(interval time.Duration, threshold int) {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+	if p.healthCancel != nil {
+		p.healthCancel()
+		p.healthCancel = nil
+	}
+	atomic.StoreInt32(&p.healthThreshold, int32(threshold))
+	cooldown := interval
+	if cooldown <= 0 {
+		cooldown = defaultHealthCooldown
+	}
+	atomic.StoreInt64(&p.healthCooldown, int64(cooldown))
+	if threshold <= 0 {
+		p.health.Store(map[string]*peerHealth{})
+		return
+	}
+	if interval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		p.healthCancel = cancel
+		go p.probeLoop(ctx, interval)
+	}
+}
+
+// probeLoop 每隔 interval 主动探测所有节点
+func (p *peerPool) probeLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.probe(ctx, interval)
+		}
+	}
+}
+
+// probe 探测一次所有节点，探测超时时间为 interval
+func (p *peerPool) probe(ctx context.Context, interval time.Duration) {
+	p.mu.RLock()
+	getters := make(map[string]PeerGetter, len(p.getters))
+	for addr, getter := range p.getters {
+		if addr != p.self {
+			getters[addr] = getter
+		}
+	}
+	p.mu.RUnlock()
+
+	for addr, getter := range getters {
+		if lg, ok := getter.(*loadGetter); ok { //探测不计入节点负载
+			getter = lg.PeerGetter
+		}
+		if pg, ok := getter.(*PolicyGetter); ok { //探测不经过熔断器
+			getter = pg.PeerGetter
+		}
+		checker, ok := getter.(healthChecker)
+		if !ok { //不支持探测的节点依靠试探请求恢复
+			continue
+		}
+		probeCtx, cancel := context.WithTimeout(ctx, interval)
+		err := checker.Health(probeCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		p.report(addr, err)
+	}
+}
+
+// report 记录一次对节点的请求或探测结果，连续失败达到阈值时将节点移出，节点恢复后重新加入
+// 节点健康时只有原子读，不加锁
+func (p *peerPool) report(addr string, err error) {
+	threshold := atomic.LoadInt32(&p.healthThreshold)
+	if threshold <= 0 {
+		return
+	}
+	h := p.peerHealth(addr)
+	if err == nil {
+		if h == nil {
+			return
+		}
+		if atomic.LoadInt32(&h.down) == 1 {
+			p.restore(addr, h)
+		} else if atomic.LoadInt32(&h.failures) != 0 {
+			atomic.StoreInt32(&h.failures, 0)
+		}
+		return
+	}
+
+	if h == nil {
+		h = p.addPeerHealth(addr)
+	}
+	failures := atomic.AddInt32(&h.failures, 1)
+	if failures >= threshold && atomic.CompareAndSwapInt32(&h.down, 0, 1) {
+		atomic.StoreInt64(&h.since, time.Now().UnixNano())
+		p.Log("peer %s is unhealthy after %d consecutive failures, ejected: %v", addr, failures, err)
+	}
+}
+
+// isPeerFailure 判断请求错误是否说明节点本身不可用：连接失败、超时，或节点返回网关、过载类的状态码
+// 节点正常响应但加载key失败（如数据源出错）等应用层错误不计入
+func isPeerFailure(err error) bool {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.code == http.StatusBadGateway || se.code == http.StatusServiceUnavailable || se.code == http.StatusGatewayTimeout
+	}
+	if s, ok := status.FromError(err); ok {
+		return s.Code() == codes.Unavailable || s.Code() == codes.DeadlineExceeded
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var ne net.Error
+	return errors.As(err, &ne)
+}
+
+// restore 节点恢复健康，重新加入选择节点的路径
+func (p *peerPool) restore(addr string, h *peerHealth) {
+	atomic.StoreInt32(&h.failures, 0)
+	if atomic.CompareAndSwapInt32(&h.down, 1, 0) {
+		since := time.Unix(0, atomic.LoadInt64(&h.since))
+		p.Log("peer %s recovered after %v, restored", addr, time.Since(since).Round(time.Millisecond))
+	}
+}
+
+// isDown 节点是否已因不健康被移出，无锁读取
+// 移出超过冷却时间后返回 false 一次，放行一个试探请求（半开），请求成功即恢复，失败则重新计时
+func (p *peerPool) isDown(addr string) bool {
+	h := p.peerHealth(addr)
+	if h == nil || atomic.LoadInt32(&h.down) == 0 {
+		return false
+	}
+	since := atomic.LoadInt64(&h.since)
+	if time.Since(time.Unix(0, since)) < time.Duration(atomic.LoadInt64(&p.healthCooldown)) {
+		return true
+	}
+	return !atomic.CompareAndSwapInt64(&h.since, since, time.Now().UnixNano())
+}
+
+// peerHealth 返回节点的健康状态，节点未出现过失败时返回 nil
+func (p *peerPool) peerHealth(addr string) *peerHealth {
+	health, _ := p.health.Load().(map[string]*peerHealth)
+	return health[addr]
+}
+
+// addPeerHealth 为节点创建健康状态，复制后原子替换，读取方无需加锁
+func (p *peerPool) addPeerHealth(addr string) *peerHealth {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+	health, _ := p.health.Load().(map[string]*peerHealth)
+	if h, ok := health[addr]; ok {
+		return h
+	}
+	updated := make(map[string]*peerHealth, len(health)+1)
+	for k, v := range health {
+		updated[k] = v
+	}
+	h := &peerHealth{}
+	updated[addr] = h
+	p.health.Store(updated)
+	return h
+}
+
+// forgetHealth 节点离开集群时清除其健康状态
+func (p *peerPool) forgetHealth(addr string) {
+	p.healthMu.Lock()
+	defer p.healthMu.Unlock()
+	health, _ := p.health.Load().(map[string]*peerHealth)
+	if _, ok := health[addr]; !ok {
+		return
+	}
+	updated := make(map[string]*peerHealth, len(health))
+	for k, v := range health {
+		if k != addr {
+			updated[k] = v
+		}
+	}
+	p.health.Store(updated)
+}
@@ -1,261 +1,425 @@
 package geecache
 
 import (
+	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"geecache/consistenthash"
 	"geecache/discovery"
 	pb "geecache/geecachepb"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/protobuf/proto"
+	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
 	"net/url"
-	"strconv"
 	"strings"
-	"sync"
+	"time"
 )
 
 const (
 	defaultBasePath = "/_geecache/"
-)
+	ringPath        = "_ring" // 管理接口，GET /<basepath>/_ring 返回哈希环状态
 
-var defaultReplicas = 50 //默认副本数
+	defaultMaxIdleConnsPerHost = 64               // 每个远程节点默认保留的空闲连接数，http.DefaultTransport 只有 2 个，高并发时频繁新建连接
+	defaultIdleConnTimeout     = 90 * time.Second // 空闲连接默认保留时间
+	defaultDialTimeout         = 5 * time.Second  // h2c、TLS 建立连接的超时时间，避免远程节点不可达时请求一直阻塞在建连上
+)
 
 // HTTPPool 承载节点间 HTTP 通信的服务端
 type HTTPPool struct {
-	self        string                 // 地址，IP+端口
-	basePath    string                 // 节点间通讯地址的前缀，默认是 /_geecache/，因为一个主机上还可能承载其他的服务，加一段 Path 是一个好习惯
-	mu          sync.Mutex             // 保护 peers 和 httpGetters
-	peers       *consistenthash.Map    // 类型是一致性哈希算法的 consistenthash.Map
-	peersEtcd   map[string]string      // map[远程节点etcd key] 远程节点addr:ip
-	httpGetters map[string]*httpGetter // 映射远程节点与对应的客户端 httpGetter ,每一个远程节点对应一个客户端
-	register    *discovery.Register    // etcd注册服务
-	replicas    int                    // 哈希环副本数
+	*peerPool
+	basePath string        // 节点间通讯地址的前缀，默认是 /_geecache/，因为一个主机上还可能承载其他的服务，加一段 Path 是一个好习惯
+	client   *http.Client  // 访问远程节点的客户端，所有节点共用，不使用 http.DefaultClient
+	h2c      bool          // 节点间使用不加密的 HTTP/2 通讯
+	tls      *PeerTLS      // 节点间使用 TLS 通讯，nil 表示使用 http
+	auth     Authenticator // 节点间请求认证，nil 表示不认证
 }
 
-func NewHTTPPool(self string, register *discovery.Register) *HTTPPool {
-	return &HTTPPool{
-		self:        self,
-		basePath:    defaultBasePath,
-		register:    register,
-		httpGetters: make(map[string]*httpGetter),
-		peersEtcd:   make(map[string]string),
-	}
+// HTTPPoolOptions HTTPPool 的可选配置，零值字段使用默认值
+type HTTPPoolOptions struct {
+	// Client 访问远程节点的客户端，设置后忽略 RoundTripper 及连接相关的配置
+	Client *http.Client
+	// RoundTripper 访问远程节点使用的 http.RoundTripper，设置后忽略连接相关的配置
+	RoundTripper http.RoundTripper
+
+	MaxIdleConnsPerHost int           // 每个远程节点保留的空闲连接数，默认 defaultMaxIdleConnsPerHost
+	MaxConnsPerHost     int           // 每个远程节点的最大连接数，<= 0 表示不限制
+	IdleConnTimeout     time.Duration // 空闲连接保留时间，默认 defaultIdleConnTimeout
+	DisableKeepAlives   bool          // 每个请求使用新连接
+	// H2C 节点间使用不加密的 HTTP/2（h2c）通讯，多个请求复用一个连接，上述连接相关的配置不再生效，
+	// 集群内所有节点需一致，服务端需使用 HTTPPool.Handler，设置了 TLS 时忽略
+	H2C bool
+	// TLS 节点间使用 https 通讯，集群内所有节点需一致，服务端需使用 HTTPPool.ListenAndServe 或 PeerTLS.ServerConfig
+	// 设置了 Client 或 RoundTripper 时，需自行使用 PeerTLS.DialTLSContext 配置
+	TLS *PeerTLS
+
+	// Auth 节点间请求认证，默认为 HMACAuth，共享密钥从服务发现的 discovery.AuthSecret 配置中获取并随配置更新
+	Auth Authenticator
+
+	// Transport 包装最终使用的 http.RoundTripper，可用于注入监控埋点或在测试中替换为假实现
+	Transport func(next http.RoundTripper) http.RoundTripper
 }
 
-// Work 从etcd中获取集群节点信息并监听集群变化 维护哈希环与该节点的http客户端
-func (p *HTTPPool) Work() error {
-	// 设置哈希环真实节点对应的副本数
-	if err := p.setReplicas(); err != nil {
-		return err
-	}
-
-	// 创建一致性哈希环
-	p.peers = consistenthash.New(p.replicas, nil)
-
-	// 从etcd中获取当前集群节点信息，添加进哈希环，并监听节点变动
-	if err := p.addNowNodesToPeers(); err != nil {
-		return err
-	}
-
-	// 监听整个集群变动，并根据节点注册信息维护 p.peers
-	p.watchCluster()
-	return nil
+// NewHTTPPool 构造 HTTPPool，调用 Work 后通过服务发现 d 维护集群节点，d 为 nil 时需调用 Set 设置固定的节点列表
+func NewHTTPPool(self string, d discovery.Discovery) *HTTPPool {
+	return NewHTTPPoolOpts(self, d, nil)
 }
 
-// WatchCluster 监听集群节点变化，并重新维护哈希环
-func (p *HTTPPool) watchCluster() {
-	discovery.EtcdService.WatchPrefix(context.Background(), discovery.ClusterPrefix, p.addBackFun(), p.delBackFun())
-}
-
-// initReplicas 设置哈希环真实节点对应的副本数
-func (p *HTTPPool) setReplicas() error {
-	p.replicas = defaultReplicas //默认副本数
-
-	// 创建哈希环节点时，从etcd中获取真实节点的副本数
-	replicasNum, err := discovery.EtcdService.GetKey(discovery.ConsistentHashReplicasNum)
-	if err != nil {
-		return errors.New("etcd 查询失败：" + err.Error())
+// NewHTTPPoolOpts 按 opts 构造 HTTPPool，opts 为 nil 时使用默认配置
+func NewHTTPPoolOpts(self string, d discovery.Discovery, opts *HTTPPoolOptions) *HTTPPool {
+	if opts == nil {
+		opts = &HTTPPoolOptions{}
 	}
-	replicasNumInt, err := strconv.Atoi(replicasNum)
-	if err != nil {
-		return errors.New("etcd " + discovery.ConsistentHashReplicasNum + "配置转换格式出错：" + err.Error())
+	p := &HTTPPool{basePath: defaultBasePath, client: opts.client(), h2c: opts.H2C && opts.TLS == nil, tls: opts.TLS, auth: opts.Auth}
+	if p.auth == nil {
+		p.auth = NewHMACAuth("")
 	}
-	if replicasNumInt > 0 {
-		p.replicas = replicasNumInt
+	scheme := "http://"
+	if p.tls != nil {
+		scheme = "https://"
 	}
+	// 建立节点与该节点客户端 httpGetter 的映射关系
+	p.peerPool = newPeerPool(self, d, func(addr string) PeerGetter {
+		return &httpGetter{baseURL: scheme + addr + p.basePath, client: p.client, auth: p.auth}
+	})
+	return p
+}
 
-	// 监听配置变化，并更具变化做出响应操作
-	updateFun := func(ctx context.Context, keyInfo discovery.WatchInfo) { // 更新时
-		replicas, putErr := strconv.Atoi(keyInfo.Value)
-		if putErr != nil {
-			fmt.Println("etcd " + discovery.ConsistentHashReplicasNum + "配置转换格式出错：" + err.Error())
-			return
+// Work 通过服务发现维护集群节点，使用 HMACAuth 时从集群配置中获取共享密钥并监听其变动
+func (p *HTTPPool) Work() error {
+	if auth, ok := p.auth.(*HMACAuth); ok && p.discovery != nil {
+		secret, err := p.discovery.GetConfig(discovery.AuthSecret)
+		if err != nil {
+			return errors.New("集群配置查询失败：" + err.Error())
 		}
-		if replicas > 0 {
-			p.replicas = replicas
+		auth.SetSecret(secret)
+		updateFun := func(ctx context.Context, keyInfo discovery.WatchInfo) {
+			auth.SetSecret(keyInfo.Value)
+			p.Log("auth secret updated")
 		}
-		p.peers.SetReplicas(p.replicas)
-	}
-	delFun := func(ctx context.Context, keyInfo discovery.WatchInfo) { // 删除时
-		p.replicas = defaultReplicas
-		p.peers.SetReplicas(p.replicas)
+		delFun := func(ctx context.Context, keyInfo discovery.WatchInfo) {
+			auth.SetSecret("")
+			p.Log("auth secret removed, peer requests are no longer authenticated")
+		}
+		_ = p.discovery.WatchConfig(context.Background(), discovery.AuthSecret, updateFun, delFun)
 	}
-	_ = discovery.EtcdService.WatchKey(context.Background(), discovery.ConsistentHashReplicasNum, updateFun, delFun)
-	return nil
+	return p.peerPool.Work()
 }
 
-func (p *HTTPPool) addNowNodesToPeers() error {
-	// 获取当前集群节点信息
-	nodesInfo, err := p.register.GetNowNodes()
-	if err != nil {
-		return err
+// client 按配置创建访问远程节点的客户端
+func (o *HTTPPoolOptions) client() *http.Client {
+	if o.Client != nil {
+		c := *o.Client
+		if o.Transport != nil {
+			rt := c.Transport
+			if rt == nil {
+				rt = http.DefaultTransport
+			}
+			c.Transport = o.Transport(rt)
+		}
+		return &c
 	}
 
-	for addr, key := range nodesInfo {
-		p.add(addr, key)
+	rt := o.RoundTripper
+	if rt == nil {
+		rt = o.transport()
 	}
-	return nil
-}
-
-// Add 添加节点
-func (p *HTTPPool) add(addr, etcdKey string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	// hash环添加节点
-	p.peers.Add(addr)
-	// 建立节点与该节点客户端映射关系
-	p.httpGetters[addr] = &httpGetter{baseURL: "http://" + addr + p.basePath}
-
-	// 该key未监听过，创建监听
-	if _, ok := p.peersEtcd[etcdKey]; !ok {
-		p.peersEtcd[etcdKey] = addr
-		// 开始监听
-		discovery.EtcdService.WatchKey(context.Background(), etcdKey, p.addBackFun(), p.delBackFun())
+	if o.Transport != nil {
+		rt = o.Transport(rt)
 	}
+	return &http.Client{Transport: rt}
 }
 
-// addBackFun 有新节点加入集群时/或者原节点addr变动 执行的回调
-func (p *HTTPPool) addBackFun() discovery.KeyEventFun {
-	return func(ctx context.Context, keyInfo discovery.WatchInfo) { //keyInfo key为/gee_cache/序号 value为节点addr
-		p.mu.Lock()
-		defer p.mu.Unlock()
-		etcdKey := keyInfo.Key
-		addr := keyInfo.Value
-
-		//删除旧节点信息
-		oldAddr := p.peersEtcd[etcdKey]
-		p.peers.Del(oldAddr)
-
-		//添加新节点信息
-		p.peers.Add(addr)
-		p.httpGetters[addr] = &httpGetter{baseURL: "http://" + addr + p.basePath}
-		p.peersEtcd[etcdKey] = addr
-		fmt.Printf("集群新增节点 %s => %s\n", etcdKey, addr)
+// transport 按连接相关的配置创建 http.RoundTripper
+func (o *HTTPPoolOptions) transport() http.RoundTripper {
+	if o.H2C && o.TLS == nil {
+		// h2c 不经过 TLS 协商，直接以 HTTP/2 建立明文连接
+		// 当前 x/net 版本的 http2.Transport 不支持 DialTLSContext，建连无法随请求取消，只能通过超时限制
+		dialer := &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: 30 * time.Second}
+		return &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, cfg *tls.Config) (net.Conn, error) {
+				return dialer.Dial(network, addr)
+			},
+		}
 	}
-}
 
-// delBackFun 集群中有节点移除时 执行的回调
-func (p *HTTPPool) delBackFun() discovery.KeyEventFun {
-	return func(ctx context.Context, keyInfo discovery.WatchInfo) { //keyInfo key为/gee_cache/序号 value为""
-		etcdKey := keyInfo.Key
-		addr := p.peersEtcd[etcdKey]
-		p.del(addr)
-		fmt.Printf("集群移除节点 %s => %s\n", etcdKey, addr)
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.MaxIdleConnsPerHost = o.MaxIdleConnsPerHost
+	if t.MaxIdleConnsPerHost <= 0 {
+		t.MaxIdleConnsPerHost = defaultMaxIdleConnsPerHost
+	}
+	t.MaxIdleConns = 0 //只按节点限制空闲连接数
+	t.MaxConnsPerHost = o.MaxConnsPerHost
+	t.IdleConnTimeout = o.IdleConnTimeout
+	if t.IdleConnTimeout <= 0 {
+		t.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	t.DisableKeepAlives = o.DisableKeepAlives
+	if o.TLS != nil { //按实际访问的地址校验对端证书，TLS 握手时通过 ALPN 协商 HTTP/2
+		t.DialTLSContext = o.TLS.DialTLSContext
 	}
+	return t
 }
 
-// Del 删除节点
-func (p *HTTPPool) del(addr string) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-
-	p.peers.Del(addr)
-	delete(p.httpGetters, addr)
+// Handler 返回用于启动节点服务的 http.Handler，开启 H2C 时同时接受 h2c 与 HTTP/1.1 请求
+func (p *HTTPPool) Handler() http.Handler {
+	if p.h2c {
+		return h2c.NewHandler(p, &http2.Server{})
+	}
+	return p
 }
 
-// Log 打印日志
-func (p *HTTPPool) Log(format string, v ...interface{}) {
-	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
+// ListenAndServe 在 addr 上启动节点服务，设置了 TLS 时使用 https
+func (p *HTTPPool) ListenAndServe(addr string) error {
+	server := &http.Server{Addr: addr, Handler: p.Handler()}
+	if p.tls == nil {
+		return server.ListenAndServe()
+	}
+	server.TLSConfig = p.tls.ServerConfig()
+	return server.ListenAndServeTLS("", "") //证书由 TLSConfig 提供
 }
 
 // ServeHTTP 实现 http.Handler
 // 我们约定访问路径格式为 /<basepath>/<groupname>/<key>，通过 groupname 得到 group 实例，再使用 group.Get(key) 获取缓存数据。
+// DELETE 方法表示移除该节点上key对应的缓存，只移除本地，不再向其他节点广播
+// PUT 方法表示将请求体中 pb.SetRequest 携带的值写入该节点的缓存
+// POST /<basepath>/<groupname> 表示批量获取，请求体为 pb.BatchRequest，响应体为 pb.BatchResponse
+// PUT /<basepath>/<groupname> 表示批量写入，请求体为 pb.BulkSetRequest，用于哈希环变动后迁移key
+// GET /<basepath>/_ring 为管理接口，返回哈希环状态，见 RingStatus
+// GET /<basepath>/_health 为健康检查接口，其他节点定期探测，返回 200 表示该节点可以正常处理请求
+// 除健康检查外的请求需通过 Authenticator 校验，否则返回 401
 func (p *HTTPPool) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if !strings.HasPrefix(r.URL.Path, p.basePath) {
 		//panic("HTTPPool serving unexpected path: " + r.URL.Path)
 		http.Error(w, "HTTPPool serving unexpected path: "+r.URL.Path, http.StatusNotFound)
 		return
 	}
+	if r.URL.Path == p.basePath+healthPath { //探测频繁，不打印日志
+		w.Write([]byte("ok"))
+		return
+	}
 	p.Log("%s %s", r.Method, r.URL.Path)
+	if p.auth != nil {
+		if err := p.auth.Verify(r); err != nil {
+			p.Log("rejected %s %s from %s: %v", r.Method, r.URL.Path, r.RemoteAddr, err)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	if r.URL.Path == p.basePath+ringPath {
+		p.serveRing(w, r)
+		return
+	}
 	// /<basepath>/<groupname>/<key> required
 	parts := strings.SplitN(r.URL.Path[len(p.basePath):], "/", 2) //只获取groupname和key部分
-	if len(parts) != 2 {
+	batch := len(parts) == 1
+	if batch && r.Method != http.MethodPost && r.Method != http.MethodPut || !batch && r.Method == http.MethodPost {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	groupName := parts[0]
-	key := parts[1]
-
 	group := GetGroup(groupName)
 	if group == nil {
 		http.Error(w, "no such group: "+groupName, http.StatusNotFound)
 		return
 	}
 
-	view, err := group.Get(key)
+	if batch {
+		if r.Method == http.MethodPost {
+			p.serveBatch(w, r, group)
+		} else {
+			p.serveSetMany(w, r, group)
+		}
+		return
+	}
+
+	key := parts[1]
+	switch r.Method {
+	case http.MethodDelete:
+		group.removeLocally(key)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPut:
+		p.serveSet(w, r, group, key)
+	default:
+		p.serveGet(w, r, group, key)
+	}
+}
+
+// serveGet 获取key对应的缓存，响应体为 pb.Response，key不存在时 pb.Response.NotFound 为 true
+func (p *HTTPPool) serveGet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	out, err := group.getResponse(r.Context(), key)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	// 使用Protobuf 序列化
-	body, err := proto.Marshal(&pb.Response{Value: view.ByteSlice()})
+	writeProto(w, out)
+}
+
+// serveSet 将请求体中 pb.SetRequest 携带的值写入本地缓存
+func (p *HTTPPool) serveSet(w http.ResponseWriter, r *http.Request, group *Group, key string) {
+	in := &pb.SetRequest{}
+	if err := readProto(r.Body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	group.setLocally(key, ByteView{b: in.GetValue(), e: expireFromUnixNano(in.GetExpire())})
+	w.WriteHeader(http.StatusOK)
+}
+
+// serveBatch 批量获取请求体中 pb.BatchRequest 携带的key，单个key的错误记录在对应结果中
+func (p *HTTPPool) serveBatch(w http.ResponseWriter, r *http.Request, group *Group) {
+	in := &pb.BatchRequest{}
+	if err := readProto(r.Body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeProto(w, group.getManyResponse(r.Context(), in.GetKeys()))
+}
+
+// serveRing 以 JSON 格式返回哈希环状态，包括各节点拥有的区间、所占比例及最近一次变动中归属发生变化的区间
+func (p *HTTPPool) serveRing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := json.Marshal(p.RingStatus())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	w.Header().Set("Content-Type", "application/octet-stream") //字节流
+	w.Header().Set("Content-Type", "application/json")
 	w.Write(body)
 }
 
-// PickPeer 根据具体的 key，选择节点，返回节点对应的 HTTP 客户端
-func (p *HTTPPool) PickPeer(key string) (PeerGetter, bool) {
-	p.mu.Lock()
-	defer p.mu.Unlock()
-	if peer := p.peers.Get(key); peer != "" && peer != p.self { //节点不能是自己
-		p.Log("Pick peer %s", peer)
-		return p.httpGetters[peer], true
+// serveSetMany 将请求体中 pb.BulkSetRequest 携带的多个kv写入本地缓存
+func (p *HTTPPool) serveSetMany(w http.ResponseWriter, r *http.Request, group *Group) {
+	in := &pb.BulkSetRequest{}
+	if err := readProto(r.Body, in); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	return nil, false
+	group.setManyLocally(in.GetEntries())
+	w.WriteHeader(http.StatusOK)
+}
+
+// readProto 读取请求体并使用 Protobuf 反序列化
+func readProto(r io.Reader, m proto.Message) error {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return proto.Unmarshal(body, m)
+}
+
+// writeProto 使用 Protobuf 序列化后写入响应体
+func writeProto(w http.ResponseWriter, m proto.Message) {
+	body, err := proto.Marshal(m)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream") //字节流
+	w.Write(body)
 }
 
 var _ PeerPicker = (*HTTPPool)(nil)
 
 // httpGetter 缓存服务http客户端，实现 PeerGetter 接口
 type httpGetter struct {
-	baseURL string //表示将要访问的远程节点的地址，例如 http://example.com/_geecache/
+	baseURL string        //表示将要访问的远程节点的地址，例如 http://example.com/_geecache/
+	client  *http.Client  //为 nil 时使用 http.DefaultClient
+	auth    Authenticator //为请求签名，nil 表示不签名
 }
 
-func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
-	u := fmt.Sprintf(
+// url 拼接远程节点上 group 和 key 对应的地址
+func (h *httpGetter) url(group, key string) string {
+	return fmt.Sprintf(
 		"%v%v/%v",
 		h.baseURL,
-		url.QueryEscape(in.GetGroup()),
-		url.QueryEscape(in.GetKey()),
+		url.QueryEscape(group),
+		url.QueryEscape(key),
 	)
-	res, err := http.Get(u)
+}
+
+func (h *httpGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return h.do(ctx, http.MethodGet, h.url(in.GetGroup(), in.GetKey()), nil, out)
+}
+
+// GetMany 批量获取远程节点上的key
+func (h *httpGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	u := h.baseURL + url.QueryEscape(in.GetGroup())
+	return h.do(ctx, http.MethodPost, u, bytes.NewReader(body), out)
+}
+
+// Remove 通知远程节点移除key
+func (h *httpGetter) Remove(ctx context.Context, in *pb.Request) error {
+	return h.do(ctx, http.MethodDelete, h.url(in.GetGroup(), in.GetKey()), nil, nil)
+}
+
+// Set 将kv写入远程节点
+func (h *httpGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	return h.do(ctx, http.MethodPut, h.url(in.GetGroup(), in.GetKey()), bytes.NewReader(body), nil)
+}
+
+// SetMany 将多个kv批量写入远程节点
+func (h *httpGetter) SetMany(ctx context.Context, in *pb.BulkSetRequest) error {
+	body, err := proto.Marshal(in)
+	if err != nil {
+		return fmt.Errorf("encoding request body: %v", err)
+	}
+	u := h.baseURL + url.QueryEscape(in.GetGroup())
+	return h.do(ctx, http.MethodPut, u, bytes.NewReader(body), nil)
+}
+
+// Health 探测远程节点是否健康
+func (h *httpGetter) Health(ctx context.Context) error {
+	return h.do(ctx, http.MethodGet, h.baseURL+healthPath, nil, nil)
+}
+
+// do 向远程节点发起请求，远程节点返回非 200 时返回错误，out 不为 nil 时将响应体反序列化至 out
+func (h *httpGetter) do(ctx context.Context, method, u string, body io.Reader, out proto.Message) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if h.auth != nil {
+		if err = h.auth.Sign(req); err != nil {
+			return fmt.Errorf("signing request: %v", err)
+		}
+	}
+	client := h.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
 	if err != nil {
 		return err
 	}
 	defer res.Body.Close()
 
 	if res.StatusCode != http.StatusOK {
-		return fmt.Errorf("server returned: %v", res.Status)
+		return &statusError{code: res.StatusCode, status: res.Status}
+	}
+	if out == nil {
+		return nil
 	}
 
 	bytes, err := ioutil.ReadAll(res.Body)
@@ -269,5 +433,15 @@ func (h *httpGetter) Get(in *pb.Request, out *pb.Response) error {
 	return nil
 }
 
+// statusError 远程节点返回的非 200 响应
+type statusError struct {
+	code   int
+	status string
+}
+
+func (e *statusError) Error() string {
+	return "server returned: " + e.status
+}
+
 // 判断 httpGetter 是否实现 PeerGetter 接口
 var _ PeerGetter = (*httpGetter)(nil)
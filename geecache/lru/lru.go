@@ -1,23 +1,48 @@
 package lru
 
-import "container/list"
+import (
+	"container/list"
+	"time"
+)
+
+// sweepSamples 每次写入时顺带抽查的条目数，用于摊还清理过期条目
+const sweepSamples = 4
+
+// now 获取当前时间，测试时可替换
+var now = time.Now
+
+// EvictReason 条目被移除的原因
+type EvictReason int
+
+const (
+	EvictCapacity EvictReason = iota // 超出内存上限被淘汰
+	EvictExpired                     // 条目过期被淘汰
+	EvictRemoved                     // 调用 Remove 主动移除
+)
 
 // Cache LRU缓存，非并发安全实现
 // LRU 缓存淘汰策略，最近最少使用。LRU 认为，如果数据最近被访问过，那么将来被访问的概率也会更高。
 // 维护一个队列，如果某条记录被访问了，则移动到队尾，那么队首则是最近最少访问的数据，淘汰该条记录即可。
 // 队首队尾是相对的，ll是双向链表，这里我们规定队列ll的队尾存放最少访问的数据，队首存放最频繁访问的数据
 type Cache struct {
-	maxBytes  int64                         //允许使用的最大内存
-	nbytes    int64                         //当前已使用的内存
-	ll        *list.List                    //队列
-	cache     map[string]*list.Element      //键是字符串，值是双向链表中对应节点的指针
-	OnEvicted func(key string, value Value) //某条记录被移除时的回调函数，可以为 nil
+	maxBytes  int64                                             //允许使用的最大内存
+	nbytes    int64                                             //当前已使用的内存
+	nexpire   int                                               //设置了过期时间的条目数，为0时无需清理
+	ll        *list.List                                        //队列
+	cache     map[string]*list.Element                          //键是字符串，值是双向链表中对应节点的指针
+	OnEvicted func(key string, value Value, reason EvictReason) //某条记录被移除时的回调函数，可以为 nil
 }
 
 // entry kv键值缓存
 type entry struct {
-	key   string
-	value Value
+	key    string
+	value  Value
+	expire time.Time //过期时间，零值表示永不过期
+}
+
+// expired 判断条目在t时刻是否已过期
+func (e *entry) expired(t time.Time) bool {
+	return !e.expire.IsZero() && !t.Before(e.expire)
 }
 
 // Value 返回值所占用的内存大小
@@ -26,7 +51,7 @@ type Value interface {
 }
 
 // New 构造Cache
-func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
+func New(maxBytes int64, onEvicted func(string, Value, EvictReason)) *Cache {
 	return &Cache{
 		maxBytes:  maxBytes,
 		ll:        list.New(),
@@ -35,51 +60,148 @@ func New(maxBytes int64, onEvicted func(string, Value)) *Cache {
 	}
 }
 
-// Get 查找缓存中key对应的value值
+// Get 查找缓存中key对应的value值，已过期的条目视为未命中并被移除
 func (c *Cache) Get(key string) (value Value, ok bool) {
 	// 1.从字典中找到对应的双向链表的节点
 	if ele, ok := c.cache[key]; ok {
+		kv := ele.Value.(*entry)
+		if kv.expired(now()) { //过期，惰性删除
+			c.removeElement(ele, EvictExpired)
+			return nil, false
+		}
 		// 2.将该节点移动到队首。那么ll队尾为最少访问的节点
 		c.ll.MoveToFront(ele)
-		kv := ele.Value.(*entry)
 		return kv.value, true
 	}
 	return
 }
 
-// Add 添加kv缓存
+// Add 添加kv缓存，永不过期
 func (c *Cache) Add(key string, value Value) {
+	c.AddWithExpire(key, value, time.Time{})
+}
+
+// AddWithTTL 添加kv缓存，ttl后过期，ttl <= 0 表示永不过期
+func (c *Cache) AddWithTTL(key string, value Value, ttl time.Duration) {
+	var expire time.Time
+	if ttl > 0 {
+		expire = now().Add(ttl)
+	}
+	c.AddWithExpire(key, value, expire)
+}
+
+// AddWithExpire 添加kv缓存，在expire时刻过期，expire为零值表示永不过期
+func (c *Cache) AddWithExpire(key string, value Value, expire time.Time) {
 	if ele, ok := c.cache[key]; ok { //如果键存在，则更新对应节点的值，并将该节点移到队首
 		c.ll.MoveToFront(ele)
 		kv := ele.Value.(*entry)
 		c.nbytes += int64(value.Len()) - int64(kv.value.Len())
 		kv.value = value
+		c.setExpire(kv, expire)
 	} else { //不存则新增，首先队首添加新节点, 并字典中添加 key 和节点的映射关系。
-		ele = c.ll.PushFront(&entry{key, value})
+		kv := &entry{key: key, value: value}
+		c.setExpire(kv, expire)
+		ele = c.ll.PushFront(kv)
 		c.cache[key] = ele
 		c.nbytes += int64(len(key)) + int64(value.Len())
 	}
+	// 顺带抽查部分条目，清理已过期的，摊还清理成本
+	c.sweep(sweepSamples)
 	//当前使用内存超出最大内存，惰性删除
 	for c.maxBytes != 0 && c.maxBytes < c.nbytes {
 		c.RemoveOldest()
 	}
 }
 
+// setExpire 设置条目过期时间，并维护 nexpire 计数
+func (c *Cache) setExpire(kv *entry, expire time.Time) {
+	if !kv.expire.IsZero() {
+		c.nexpire--
+	}
+	if !expire.IsZero() {
+		c.nexpire++
+	}
+	kv.expire = expire
+}
+
+// sweep 抽查最多n个条目，移除其中已过期的。map遍历顺序随机，多次调用后可覆盖全部条目
+func (c *Cache) sweep(n int) {
+	if c.nexpire == 0 {
+		return
+	}
+	t := now()
+	for _, ele := range c.cache {
+		if n <= 0 {
+			break
+		}
+		n--
+		if ele.Value.(*entry).expired(t) {
+			c.removeElement(ele, EvictExpired)
+		}
+	}
+}
+
+// RemoveExpired 移除全部已过期的条目，返回移除的条目数。可由调用方定期执行作为后台清理
+func (c *Cache) RemoveExpired() int {
+	if c.nexpire == 0 {
+		return 0
+	}
+	t := now()
+	removed := 0
+	for _, ele := range c.cache {
+		if ele.Value.(*entry).expired(t) {
+			c.removeElement(ele, EvictExpired)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Remove 移除key对应的条目
+func (c *Cache) Remove(key string) {
+	if ele, ok := c.cache[key]; ok {
+		c.removeElement(ele, EvictRemoved)
+	}
+}
+
 // RemoveOldest 缓存淘汰,移除最近最少访问的节点（ll队尾）
 func (c *Cache) RemoveOldest() {
 	// 1.获取队尾节点
 	ele := c.ll.Back()
 	if ele != nil {
 		// 2.移除该节点
-		c.ll.Remove(ele)
+		c.removeElement(ele, EvictCapacity)
+	}
+}
+
+// removeElement 移除节点，并执行回调事件
+func (c *Cache) removeElement(ele *list.Element, reason EvictReason) {
+	c.ll.Remove(ele)
+	kv := ele.Value.(*entry)
+	// 删除map中该节点的映射关系
+	delete(c.cache, kv.key)
+	// 重新计算Cache占用内存
+	c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
+	if !kv.expire.IsZero() {
+		c.nexpire--
+	}
+	// 执行回调事件
+	if c.OnEvicted != nil {
+		c.OnEvicted(kv.key, kv.value, reason)
+	}
+}
+
+// Range 按最近访问从新到旧遍历未过期的条目，不改变条目的访问顺序，fn 返回 false 时停止遍历
+// 遍历过程中不能修改缓存
+func (c *Cache) Range(fn func(key string, value Value) bool) {
+	t := now()
+	for ele := c.ll.Front(); ele != nil; ele = ele.Next() {
 		kv := ele.Value.(*entry)
-		// 3.删除map中该节点的映射关系
-		delete(c.cache, kv.key)
-		// 4.重新计算Cache占用内存
-		c.nbytes -= int64(len(kv.key)) + int64(kv.value.Len())
-		// 5.执行回调事件
-		if c.OnEvicted != nil {
-			c.OnEvicted(kv.key, kv.value)
+		if !kv.expire.IsZero() && !t.Before(kv.expire) {
+			continue
+		}
+		if !fn(kv.key, kv.value) {
+			return
 		}
 	}
 }
@@ -88,3 +210,8 @@ func (c *Cache) RemoveOldest() {
 func (c *Cache) Len() int {
 	return c.ll.Len()
 }
+
+// Bytes 获取缓存当前使用的内存
+func (c *Cache) Bytes() int64 {
+	return c.nbytes
+}
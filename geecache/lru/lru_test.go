@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 )
 
 type String string
@@ -46,7 +47,7 @@ func TestRemoveoldest(t *testing.T) {
 func TestOnEvicted(t *testing.T) {
 	keys := make([]string, 0)
 	//淘汰元素时执行，将被淘汰的元素存放进keys
-	callback := func(key string, value Value) {
+	callback := func(key string, value Value, reason EvictReason) {
 		keys = append(keys, key)
 	}
 	lru := New(int64(10), callback) //int64(10)，刚好可以存放k3+k4，key1和k2预期应该被淘汰
@@ -62,6 +63,72 @@ func TestOnEvicted(t *testing.T) {
 	}
 }
 
+// 测试过期条目在 Get 时视为未命中并被移除
+func TestExpire(t *testing.T) {
+	cur := time.Now()
+	now = func() time.Time { return cur }
+	defer func() { now = time.Now }()
+
+	reasons := make(map[string]EvictReason)
+	lru := New(int64(0), func(key string, value Value, reason EvictReason) {
+		reasons[key] = reason
+	})
+	lru.AddWithTTL("key1", String("1234"), time.Second)
+	lru.Add("key2", String("5678"))
+
+	if _, ok := lru.Get("key1"); !ok {
+		t.Fatalf("key1 should not expire yet")
+	}
+
+	cur = cur.Add(2 * time.Second)
+	if _, ok := lru.Get("key1"); ok {
+		t.Fatalf("key1 should be expired")
+	}
+	if reason, ok := reasons["key1"]; !ok || reason != EvictExpired || lru.Len() != 1 {
+		t.Fatalf("expired key1 should be removed with reason EvictExpired")
+	}
+	if _, ok := lru.Get("key2"); !ok {
+		t.Fatalf("key2 should never expire")
+	}
+}
+
+// 测试写入时的抽查清理和 RemoveExpired 全量清理
+func TestRemoveExpired(t *testing.T) {
+	cur := time.Now()
+	now = func() time.Time { return cur }
+	defer func() { now = time.Now }()
+
+	lru := New(int64(0), nil)
+	for i := 0; i < 10; i++ {
+		lru.AddWithTTL(fmt.Sprintf("key%d", i), String("v"), time.Second)
+	}
+	lru.Add("forever", String("v"))
+
+	cur = cur.Add(2 * time.Second)
+	lru.Add("trigger", String("v")) //写入时顺带抽查，至少清理掉部分过期条目
+	if lru.Len() >= 12 {
+		t.Fatalf("sweep on add should remove some expired entries, got len %d", lru.Len())
+	}
+
+	lru.RemoveExpired()
+	if lru.Len() != 2 || lru.nbytes != int64(len("forever")+len("trigger")+2) {
+		t.Fatalf("RemoveExpired should keep only unexpired entries, got len %d nbytes %d", lru.Len(), lru.nbytes)
+	}
+}
+
+// 测试 Remove 方法
+func TestRemove(t *testing.T) {
+	var reason EvictReason = -1
+	lru := New(int64(0), func(key string, value Value, r EvictReason) {
+		reason = r
+	})
+	lru.Add("key1", String("1234"))
+	lru.Remove("key1")
+	if _, ok := lru.Get("key1"); ok || lru.Len() != 0 || lru.nbytes != 0 || reason != EvictRemoved {
+		t.Fatalf("Remove key1 failed")
+	}
+}
+
 func TestHaha(t *testing.T) {
 	haha := []byte{1, 2, 3}
 	fmt.Println(haha)
@@ -77,3 +144,35 @@ func cloneBytes(b []byte) []byte {
 	copy(c, b)
 	return c
 }
+
+// 测试 Range 按访问顺序遍历并跳过过期条目
+func TestRange(t *testing.T) {
+	cur := time.Now()
+	now = func() time.Time { return cur }
+	defer func() { now = time.Now }()
+
+	lru := New(int64(0), nil)
+	lru.Add("key1", String("1"))
+	lru.AddWithTTL("key2", String("2"), time.Second)
+	lru.Add("key3", String("3"))
+	lru.Get("key1")
+	cur = cur.Add(2 * time.Second)
+
+	var keys []string
+	lru.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if !reflect.DeepEqual(keys, []string{"key1", "key3"}) {
+		t.Fatalf("Range should visit unexpired keys from newest to oldest, got %v", keys)
+	}
+
+	keys = keys[:0]
+	lru.Range(func(key string, value Value) bool {
+		keys = append(keys, key)
+		return false
+	})
+	if len(keys) != 1 {
+		t.Fatalf("Range should stop when fn returns false, got %v", keys)
+	}
+}
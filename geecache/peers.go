@@ -1,14 +1,33 @@
 package geecache
 
-import pb "geecache/geecachepb"
+import (
+	"context"
+	pb "geecache/geecachepb"
+)
 
 // PeerPicker 根据传入的 key 选择相应节点
 type PeerPicker interface {
 	// PickPeer 根据具体的 key，选择节点，返回节点对应的 HTTP 客户端
 	PickPeer(key string) (peer PeerGetter, ok bool)
+	// AllPeers 返回集群中除自身外所有节点的客户端，用于广播
+	AllPeers() []PeerGetter
+}
+
+// ReplicaPicker 可选接口，PeerPicker 同时实现该接口且 Group 开启复制时，key 会存放在多个节点
+type ReplicaPicker interface {
+	// PickReplicas 返回key依次所属的至多 n 个节点的客户端，第一个为主节点，节点为自身时对应位置为 nil
+	PickReplicas(key string, n int) []PeerGetter
 }
 
 // PeerGetter 对应 PeerPicker 中的节点(http客户端), 从对应 Group 查找缓存值。
 type PeerGetter interface {
-	Get(in *pb.Request, out *pb.Response) error
+	Get(ctx context.Context, in *pb.Request, out *pb.Response) error
+	// GetMany 批量获取节点对应 Group 中的key
+	GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error
+	// Remove 通知节点从对应 Group 中移除key
+	Remove(ctx context.Context, in *pb.Request) error
+	// Set 将kv写入节点对应 Group 的缓存
+	Set(ctx context.Context, in *pb.SetRequest) error
+	// SetMany 将多个kv批量写入节点对应 Group 的缓存，用于哈希环变动后迁移key
+	SetMany(ctx context.Context, in *pb.BulkSetRequest) error
 }
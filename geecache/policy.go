@@ -0,0 +1,199 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	pb "geecache/geecachepb"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrBreakerOpen 熔断器处于打开状态，请求未发往节点直接失败
+var ErrBreakerOpen = errors.New("geecache: circuit breaker is open")
+
+// Policy 节点请求策略，零值表示不限制超时、不重试、不熔断
+type Policy struct {
+	Timeout time.Duration // 单次请求的超时时间，<= 0 表示只受调用方 ctx 限制
+	// Retries Get、GetMany 因节点不可用（连接失败、超时、502/503/504）失败后的最大重试次数，Remove、Set、SetMany 不重试
+	// 节点正常响应的应用层错误（如节点的数据源出错、认证失败）不重试，重试只会让节点再次访问数据源
+	Retries int
+	// Backoff 第一次重试前的等待时间，之后每次翻倍
+	Backoff time.Duration
+	// FailureThreshold 节点连续不可用多少次后打开熔断器，<= 0 表示不熔断，应用层错误视为节点可用并重置计数
+	FailureThreshold int
+	// Cooldown 熔断器打开多久后进入半开状态，放行一个请求试探节点是否恢复
+	Cooldown time.Duration
+}
+
+// BreakerState 熔断器状态
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // 关闭，请求正常发往节点
+	BreakerOpen                         // 打开，请求直接返回 ErrBreakerOpen
+	BreakerHalfOpen                     // 半开，冷却结束后放行一个试探请求，成功则关闭，失败则重新打开
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// PolicyGetter 包装任意 PeerGetter，按 Policy 为请求设置超时、重试幂等的读请求，并在节点连续失败后熔断，
+// 避免响应缓慢的节点拖慢每一次发往它的 Group.load
+type PolicyGetter struct {
+	PeerGetter
+	policy Policy
+
+	mu       sync.Mutex
+	state    BreakerState
+	failures int       // 连续失败次数
+	openedAt time.Time // 熔断器打开的时间
+	probing  bool      // 半开状态下是否已有试探请求在进行
+}
+
+// NewPolicyGetter 构造 PolicyGetter
+func NewPolicyGetter(getter PeerGetter, policy Policy) *PolicyGetter {
+	return &PolicyGetter{PeerGetter: getter, policy: policy}
+}
+
+// State 返回熔断器当前状态
+func (g *PolicyGetter) State() BreakerState {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.state
+}
+
+func (g *PolicyGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	return g.retry(ctx, func(ctx context.Context) error {
+		return g.PeerGetter.Get(ctx, in, out)
+	})
+}
+
+func (g *PolicyGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	return g.retry(ctx, func(ctx context.Context) error {
+		return g.PeerGetter.GetMany(ctx, in, out)
+	})
+}
+
+func (g *PolicyGetter) Remove(ctx context.Context, in *pb.Request) error {
+	return g.call(ctx, func(ctx context.Context) error {
+		return g.PeerGetter.Remove(ctx, in)
+	})
+}
+
+func (g *PolicyGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	return g.call(ctx, func(ctx context.Context) error {
+		return g.PeerGetter.Set(ctx, in)
+	})
+}
+
+func (g *PolicyGetter) SetMany(ctx context.Context, in *pb.BulkSetRequest) error {
+	return g.call(ctx, func(ctx context.Context) error {
+		return g.PeerGetter.SetMany(ctx, in)
+	})
+}
+
+// Close 关闭被包装的客户端持有的连接
+func (g *PolicyGetter) Close() error {
+	if c, ok := g.PeerGetter.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// retry 执行 fn，节点不可用时按指数退避重试，至多重试 Policy.Retries 次
+// 应用层错误、熔断器打开或调用方放弃时不再重试
+func (g *PolicyGetter) retry(ctx context.Context, fn func(ctx context.Context) error) error {
+	backoff := g.policy.Backoff
+	for i := 0; ; i++ {
+		err := g.call(ctx, fn)
+		if err == nil || !isPeerFailure(err) || ctx.Err() != nil || i >= g.policy.Retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// call 经熔断器放行后执行一次 fn，超时时间为 Policy.Timeout，并记录结果
+func (g *PolicyGetter) call(ctx context.Context, fn func(ctx context.Context) error) error {
+	if err := g.allow(); err != nil {
+		return err
+	}
+	callCtx := ctx
+	if g.policy.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, g.policy.Timeout)
+		defer cancel()
+	}
+	err := fn(callCtx)
+	if ctx.Err() != nil { //调用方放弃导致的失败不计入熔断
+		g.release()
+		return err
+	}
+	g.record(err)
+	return err
+}
+
+// allow 判断熔断器是否放行请求，打开状态冷却结束后转为半开，只放行一个试探请求
+func (g *PolicyGetter) allow() error {
+	if g.policy.FailureThreshold <= 0 {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.state == BreakerOpen && time.Since(g.openedAt) >= g.policy.Cooldown {
+		g.state = BreakerHalfOpen
+	}
+	switch g.state {
+	case BreakerOpen:
+		return ErrBreakerOpen
+	case BreakerHalfOpen:
+		if g.probing {
+			return ErrBreakerOpen
+		}
+		g.probing = true
+	}
+	return nil
+}
+
+// release 放弃本次请求的结果，半开状态下允许下一个请求继续试探
+func (g *PolicyGetter) release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.probing = false
+}
+
+// record 记录请求结果，成功或应用层错误时关闭熔断器，节点连续不可用达到阈值或试探失败时打开熔断器
+func (g *PolicyGetter) record(err error) {
+	if g.policy.FailureThreshold <= 0 {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.probing = false
+	if err == nil || !isPeerFailure(err) {
+		g.state, g.failures = BreakerClosed, 0
+		return
+	}
+	g.failures++
+	if g.state == BreakerHalfOpen || g.failures >= g.policy.FailureThreshold {
+		g.state, g.openedAt = BreakerOpen, time.Now()
+	}
+}
+
+// 判断 PolicyGetter 是否实现 PeerGetter 接口
+var _ PeerGetter = (*PolicyGetter)(nil)
@@ -0,0 +1,562 @@
+package geecache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"geecache/consistenthash"
+	"geecache/discovery"
+	pb "geecache/geecachepb"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+var defaultReplicas = 50 //默认副本数
+
+// peerPool 维护哈希环及每个远程节点的客户端，通过服务发现获取集群节点信息并监听集群变化
+// HTTPPool 与 GRPCPool 共用，二者只在节点间通讯方式（newGetter 创建的客户端）上有区别
+type peerPool struct {
+	self      string                       // 地址，IP+端口
+	mu        sync.RWMutex                 // 保护 peers、nodes 和 getters，选择节点只需读锁
+	peers     consistenthash.Selector      // 节点选择算法，默认为一致性哈希环 consistenthash.Map
+	algo      string                       // 节点选择算法名称，见 consistenthash.NewSelector
+	nodes     map[string]discovery.Node    // map[远程节点id] 远程节点注册信息
+	getters   map[string]PeerGetter        // 映射远程节点与对应的客户端,每一个远程节点对应一个客户端
+	discovery discovery.Discovery          // 服务发现
+	replicas  int                          // 哈希环副本数
+	newGetter func(addr string) PeerGetter // 为节点创建客户端
+	// 有界负载系数，见 consistenthash.Map.SetLoadFactor，<= 0 表示不开启
+	loadFactor float64
+	// 最近一次哈希环变动中归属发生变化的区间，只在使用哈希环时记录
+	moves []consistenthash.Move
+	// 哈希环变动后每秒最多迁移的key数，<= 0 表示不迁移，见 SetHandoffRate
+	handoffRate   int
+	handoffCancel context.CancelFunc // 取消进行中的迁移
+
+	healthMu        sync.Mutex         // 串行化 health 的修改及主动探测的启停，可在持有 mu 时获取
+	health          atomic.Value       // 节点健康状态 map[string]*peerHealth，只记录出现过失败的节点，修改时复制后原子替换
+	healthThreshold int32              // 连续失败多少次后移出节点，<= 0 表示不检查，原子操作，见 SetHealthCheck
+	healthCooldown  int64              // 移出的节点放行试探请求的间隔，原子操作
+	healthCancel    context.CancelFunc // 停止主动探测
+
+	// 节点请求策略，nil 表示不包装，见 SetPolicy
+	policy *Policy
+}
+
+// RingStatus 节点选择算法的当前状态，由 HTTPPool 的管理接口返回，用于观察节点间归属是否均衡
+type RingStatus struct {
+	Self      string                      `json:"self"`
+	Algorithm string                      `json:"algorithm"`
+	Members   []string                    `json:"members"`
+	Nodes     []consistenthash.NodeRanges `json:"nodes,omitempty"`    // 各节点拥有的区间及比例，只在使用哈希环时返回
+	Moves     []consistenthash.Move       `json:"moves,omitempty"`    // 最近一次哈希环变动中归属发生变化的区间
+	Breakers  map[string]string           `json:"breakers,omitempty"` // 各远程节点熔断器状态，只在设置了请求策略时返回
+}
+
+func newPeerPool(self string, d discovery.Discovery, newGetter func(addr string) PeerGetter) *peerPool {
+	return &peerPool{
+		self:      self,
+		discovery: d,
+		getters:   make(map[string]PeerGetter),
+		nodes:     make(map[string]discovery.Node),
+		newGetter: newGetter,
+	}
+}
+
+// Set 使用固定的节点地址列表重建哈希环，替换原有节点，用于不依赖服务发现的集群，无需再调用 Work
+func (p *peerPool) Set(peers ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.replicas <= 0 {
+		p.replicas = defaultReplicas
+	}
+	p.changeRing(func() {
+		p.peers = p.newRing()
+		p.peers.Add(peers...)
+	})
+
+	nodes := make(map[string]discovery.Node, len(peers))
+	getters := make(map[string]PeerGetter, len(peers))
+	for _, addr := range peers {
+		nodes[addr] = discovery.Node{Addr: addr, Weight: 1}
+		if getter, ok := p.getters[addr]; ok { //复用已有客户端
+			getters[addr] = getter
+			delete(p.getters, addr)
+		} else {
+			getters[addr] = p.newPeerGetter(addr)
+		}
+	}
+	for addr := range p.getters { //关闭已不在集群中的节点客户端
+		p.closeGetter(addr)
+	}
+	p.nodes = nodes
+	p.getters = getters
+}
+
+// Work 通过服务发现获取集群节点信息并监听集群变化 维护哈希环与该节点的客户端
+func (p *peerPool) Work() error {
+	if p.discovery == nil {
+		return errors.New("服务发现未设置")
+	}
+
+	// 设置哈希环真实节点对应的副本数
+	if err := p.setReplicas(); err != nil {
+		return err
+	}
+
+	// 创建一致性哈希环
+	p.mu.Lock()
+	p.peers = p.newRing()
+	p.mu.Unlock()
+
+	// 获取当前集群节点信息，添加进哈希环
+	if err := p.addNowNodesToPeers(); err != nil {
+		return err
+	}
+
+	// 监听整个集群变动，并根据节点注册信息维护 p.peers
+	p.watchCluster()
+	return nil
+}
+
+// WatchCluster 监听集群节点变化，并重新维护哈希环
+func (p *peerPool) watchCluster() {
+	p.discovery.Watch(context.Background(), p.addBackFun(), p.delBackFun())
+}
+
+// initReplicas 设置哈希环真实节点对应的副本数
+func (p *peerPool) setReplicas() error {
+	p.replicas = defaultReplicas //默认副本数
+
+	// 创建哈希环节点时，从集群配置中获取真实节点的副本数，未配置时使用默认副本数
+	replicasNum, err := p.discovery.GetConfig(discovery.ConsistentHashReplicasNum)
+	if err != nil {
+		return errors.New("集群配置查询失败：" + err.Error())
+	}
+	if replicasNum != "" {
+		replicasNumInt, err := strconv.Atoi(replicasNum)
+		if err != nil {
+			return errors.New(discovery.ConsistentHashReplicasNum + "配置转换格式出错：" + err.Error())
+		}
+		if replicasNumInt > 0 {
+			p.replicas = replicasNumInt
+		}
+	}
+
+	// 监听配置变化，并更具变化做出响应操作
+	updateFun := func(ctx context.Context, keyInfo discovery.WatchInfo) { // 更新时
+		replicas, putErr := strconv.Atoi(keyInfo.Value)
+		if putErr != nil {
+			fmt.Println(discovery.ConsistentHashReplicasNum + "配置转换格式出错：" + putErr.Error())
+			return
+		}
+		if replicas <= 0 {
+			return
+		}
+		p.resetReplicas(replicas)
+	}
+	delFun := func(ctx context.Context, keyInfo discovery.WatchInfo) { // 删除时
+		p.resetReplicas(defaultReplicas)
+	}
+	_ = p.discovery.WatchConfig(context.Background(), discovery.ConsistentHashReplicasNum, updateFun, delFun)
+	return nil
+}
+
+// resetReplicas 修改副本数，并按当前集群节点重建哈希环，使所有节点对key的归属保持一致
+func (p *peerPool) resetReplicas(replicas int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.replicas = replicas
+	if ring, ok := p.peers.(*consistenthash.Map); ok { //副本数只对哈希环有效
+		p.changeRing(func() {
+			ring.SetReplicas(replicas)
+		})
+	}
+	fmt.Printf("哈希环副本数变更为 %d，已重建哈希环\n", replicas)
+}
+
+func (p *peerPool) addNowNodesToPeers() error {
+	// 获取当前集群节点信息
+	members, err := p.discovery.Members()
+	if err != nil {
+		return err
+	}
+
+	for id, node := range members {
+		p.add(node, id)
+	}
+	return nil
+}
+
+// Add 添加节点，按节点注册的权重在哈希环上创建虚拟节点
+func (p *peerPool) add(node discovery.Node, id string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// hash环添加节点
+	p.changeRing(func() {
+		p.peers.AddWeighted(node.Addr, node.Weight)
+	})
+	// 建立节点与该节点客户端映射关系
+	p.getters[node.Addr] = p.newPeerGetter(node.Addr)
+	p.nodes[id] = node
+}
+
+// addBackFun 有新节点加入集群时/或者原节点addr变动 执行的回调
+func (p *peerPool) addBackFun() discovery.KeyEventFun {
+	return func(ctx context.Context, keyInfo discovery.WatchInfo) { //keyInfo key为节点id value为节点注册信息
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		id := keyInfo.Key
+		node := discovery.ParseNode(keyInfo.Value)
+		addr := node.Addr
+
+		//删除旧节点信息，添加新节点信息
+		oldAddr := p.nodes[id].Addr
+		p.changeRing(func() {
+			p.peers.Del(oldAddr)
+			p.peers.AddWeighted(addr, node.Weight)
+		})
+		if oldAddr != addr {
+			p.closeGetter(oldAddr)
+		}
+		if _, ok := p.getters[addr]; !ok {
+			p.getters[addr] = p.newPeerGetter(addr)
+		}
+		p.nodes[id] = node
+		fmt.Printf("集群新增节点 %s => %s，权重 %d\n", id, addr, node.Weight)
+	}
+}
+
+// delBackFun 集群中有节点移除时 执行的回调
+func (p *peerPool) delBackFun() discovery.KeyEventFun {
+	return func(ctx context.Context, keyInfo discovery.WatchInfo) { //keyInfo key为节点id value为""
+		id := keyInfo.Key
+		addr := p.del(id)
+		fmt.Printf("集群移除节点 %s => %s\n", id, addr)
+	}
+}
+
+// Del 删除节点，返回节点addr
+func (p *peerPool) del(id string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	addr := p.nodes[id].Addr
+	delete(p.nodes, id)
+	p.changeRing(func() {
+		p.peers.Del(addr)
+	})
+	p.closeGetter(addr)
+	return addr
+}
+
+// SetLoadFactor 开启有界负载一致性哈希，节点处理中的请求数超过平均值的 c 倍时，key 顺时针分配给下一个节点
+// 负载只统计本节点发往各远程节点的请求，c <= 0 表示不开启，只对哈希环有效
+func (p *peerPool) SetLoadFactor(c float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.loadFactor = c
+	if ring, ok := p.peers.(*consistenthash.Map); ok {
+		ring.SetLoadFactor(c)
+	}
+}
+
+// SetSelector 设置节点选择算法，可选 ring（默认）、rendezvous、jump、maglev，见 consistenthash.NewSelector
+// 集群内所有节点应使用相同的算法，已有节点时按新算法重建，并将本地归属已变化的key迁移至新节点，算法未变化时不重建
+func (p *peerPool) SetSelector(algo string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := consistenthash.NewSelector(algo, p.replicas); err != nil {
+		return err
+	}
+	if algo == "" {
+		algo = consistenthash.AlgoRing
+	}
+	if algo == p.algo || algo == consistenthash.AlgoRing && p.algo == "" { //重建会清空哈希环上记录的节点负载
+		return nil
+	}
+	p.algo = algo
+	if p.peers != nil {
+		p.changeRing(func() {
+			p.peers = p.newRing()
+			for _, node := range p.nodes {
+				p.peers.AddWeighted(node.Addr, node.Weight)
+			}
+		})
+	}
+	return nil
+}
+
+// changeRing 执行修改哈希环的 fn，记录归属发生变化的区间，并将本地归属已变化的key迁移至新节点，调用方需持有 p.mu
+// 变动前后有一方未使用哈希环时无法按区间对比，清空上次记录的区间
+func (p *peerPool) changeRing(fn func()) {
+	before := p.ranges()
+	fn()
+	p.moves = nil
+	if after := p.ranges(); before != nil && after != nil {
+		p.moves = consistenthash.Diff(before, after)
+	}
+	p.startHandoff()
+}
+
+// ranges 返回哈希环的区间划分，未使用哈希环时返回 nil，调用方需持有 p.mu
+func (p *peerPool) ranges() []consistenthash.Range {
+	if ring, ok := p.peers.(*consistenthash.Map); ok {
+		return ring.Ranges()
+	}
+	return nil
+}
+
+// RingStatus 返回节点选择算法的当前状态
+func (p *peerPool) RingStatus() RingStatus {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	status := RingStatus{Self: p.self, Algorithm: p.algo, Moves: p.moves}
+	if status.Algorithm == "" {
+		status.Algorithm = consistenthash.AlgoRing
+	}
+	for addr, state := range p.breakers() {
+		if status.Breakers == nil {
+			status.Breakers = make(map[string]string)
+		}
+		status.Breakers[addr] = state.String()
+	}
+	if ring, ok := p.peers.(*consistenthash.Map); ok {
+		status.Members = ring.Members()
+		status.Nodes = ring.Ownership()
+		return status
+	}
+	for _, node := range p.nodes {
+		status.Members = append(status.Members, node.Addr)
+	}
+	sort.Strings(status.Members)
+	return status
+}
+
+// newRing 按当前配置创建节点选择器，调用方需持有 p.mu
+func (p *peerPool) newRing() consistenthash.Selector {
+	s, _ := consistenthash.NewSelector(p.algo, p.replicas) //algo 已在 SetSelector 中校验
+	if ring, ok := s.(*consistenthash.Map); ok {
+		ring.SetLoadFactor(p.loadFactor)
+	}
+	return s
+}
+
+// SetPolicy 为远程节点的客户端设置请求策略：超时、读请求重试及熔断，见 Policy
+// 只对之后创建的客户端生效，应在 Work 或 Set 之前调用
+func (p *peerPool) SetPolicy(policy Policy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.policy = &policy
+}
+
+// BreakerStates 返回各远程节点熔断器的当前状态，未设置请求策略时返回空
+func (p *peerPool) BreakerStates() map[string]BreakerState {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.breakers()
+}
+
+// breakers 返回各远程节点熔断器的当前状态，调用方需持有 p.mu
+func (p *peerPool) breakers() map[string]BreakerState {
+	states := make(map[string]BreakerState)
+	for addr, getter := range p.getters {
+		if addr == p.self {
+			continue
+		}
+		if lg, ok := getter.(*loadGetter); ok {
+			if pg, ok := lg.PeerGetter.(*PolicyGetter); ok {
+				states[addr] = pg.State()
+			}
+		}
+	}
+	return states
+}
+
+// newPeerGetter 为节点创建客户端，设置了请求策略时按策略包装，并在每次请求开始、结束时上报节点负载
+func (p *peerPool) newPeerGetter(addr string) PeerGetter {
+	getter := p.newGetter(addr)
+	if p.policy != nil {
+		getter = NewPolicyGetter(getter, *p.policy)
+	}
+	return &loadGetter{PeerGetter: getter, addr: addr, pool: p}
+}
+
+// startLoad 节点开始处理一个请求
+func (p *peerPool) startLoad(addr string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if ring, ok := p.peers.(*consistenthash.Map); ok && p.loadFactor > 0 {
+		ring.Inc(addr)
+	}
+}
+
+// finishLoad 节点处理完一个请求
+func (p *peerPool) finishLoad(addr string) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if ring, ok := p.peers.(*consistenthash.Map); ok && p.loadFactor > 0 {
+		ring.Done(addr)
+	}
+}
+
+// closeGetter 移除节点对应的客户端，客户端持有连接时一并关闭，调用方需持有 p.mu
+func (p *peerPool) closeGetter(addr string) {
+	p.forgetHealth(addr)
+	if getter, ok := p.getters[addr]; ok {
+		if c, ok := getter.(io.Closer); ok {
+			_ = c.Close()
+		}
+		delete(p.getters, addr)
+	}
+}
+
+// Log 打印日志
+func (p *peerPool) Log(format string, v ...interface{}) {
+	log.Printf("[Server %s] %s", p.self, fmt.Sprintf(format, v...))
+}
+
+// PickPeer 根据具体的 key，选择节点，返回节点对应的客户端
+func (p *peerPool) PickPeer(key string) (PeerGetter, bool) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.peers == nil { //尚未调用 Work 或 Set
+		return nil, false
+	}
+	peer := p.peers.Get(key)
+	if peer != "" && p.isDown(peer) { //节点不健康，由下一个健康节点负责
+		peer = p.nextHealthy(key)
+	}
+	if peer != "" && peer != p.self { //节点不能是自己
+		p.Log("Pick peer %s", peer)
+		return p.getters[peer], true
+	}
+	return nil, false
+}
+
+// nextHealthy 返回key依次所属的节点中第一个健康的节点，节点选择算法不支持 GetN 时返回 "" 由本地加载，调用方需持有 p.mu
+func (p *peerPool) nextHealthy(key string) string {
+	ms, ok := p.peers.(consistenthash.MultiSelector)
+	if !ok {
+		return ""
+	}
+	for _, node := range ms.GetN(key, len(p.getters)+1) {
+		if !p.isDown(node) {
+			return node
+		}
+	}
+	return ""
+}
+
+// PickReplicas 返回key依次所属的至多 n 个节点的客户端，节点为自身时对应位置为 nil
+// 副本按节点选择算法的 GetN 选出，不受有界负载影响；算法不支持 GetN（jump、maglev）时只返回主节点
+func (p *peerPool) PickReplicas(key string, n int) []PeerGetter {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.peers == nil {
+		return nil
+	}
+	var nodes []string
+	if ms, ok := p.peers.(consistenthash.MultiSelector); ok {
+		nodes = ms.GetN(key, n)
+	} else if node := p.peers.Get(key); node != "" {
+		nodes = []string{node}
+	}
+
+	peers := make([]PeerGetter, 0, len(nodes))
+	for _, node := range nodes {
+		if p.isDown(node) { //跳过不健康的节点
+			continue
+		}
+		if node == p.self {
+			peers = append(peers, nil)
+		} else {
+			peers = append(peers, p.getters[node])
+		}
+	}
+	return peers
+}
+
+// AllPeers 返回集群中除自身外所有节点的客户端
+func (p *peerPool) AllPeers() []PeerGetter {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	peers := make([]PeerGetter, 0, len(p.getters))
+	for addr, getter := range p.getters {
+		if addr != p.self {
+			peers = append(peers, getter)
+		}
+	}
+	return peers
+}
+
+var (
+	_ PeerPicker    = (*peerPool)(nil)
+	_ ReplicaPicker = (*peerPool)(nil)
+)
+
+// loadGetter 包装节点客户端，请求开始、结束时向 peerPool 上报该节点的负载，供有界负载一致性哈希使用，
+// 并上报请求结果，供节点健康检查使用
+type loadGetter struct {
+	PeerGetter
+	addr string
+	pool *peerPool
+}
+
+func (g *loadGetter) Get(ctx context.Context, in *pb.Request, out *pb.Response) error {
+	g.pool.startLoad(g.addr)
+	defer g.pool.finishLoad(g.addr)
+	return g.done(ctx, g.PeerGetter.Get(ctx, in, out))
+}
+
+func (g *loadGetter) GetMany(ctx context.Context, in *pb.BatchRequest, out *pb.BatchResponse) error {
+	g.pool.startLoad(g.addr)
+	defer g.pool.finishLoad(g.addr)
+	return g.done(ctx, g.PeerGetter.GetMany(ctx, in, out))
+}
+
+func (g *loadGetter) Remove(ctx context.Context, in *pb.Request) error {
+	g.pool.startLoad(g.addr)
+	defer g.pool.finishLoad(g.addr)
+	return g.done(ctx, g.PeerGetter.Remove(ctx, in))
+}
+
+func (g *loadGetter) Set(ctx context.Context, in *pb.SetRequest) error {
+	g.pool.startLoad(g.addr)
+	defer g.pool.finishLoad(g.addr)
+	return g.done(ctx, g.PeerGetter.Set(ctx, in))
+}
+
+func (g *loadGetter) SetMany(ctx context.Context, in *pb.BulkSetRequest) error {
+	g.pool.startLoad(g.addr)
+	defer g.pool.finishLoad(g.addr)
+	return g.done(ctx, g.PeerGetter.SetMany(ctx, in))
+}
+
+// done 上报请求结果，只有连接失败、超时等说明节点不可用的错误计入失败，见 isPeerFailure
+// 调用方放弃或熔断器拒绝（请求未发往节点）时不上报；节点返回了应用层错误说明节点可达，视为成功
+func (g *loadGetter) done(ctx context.Context, err error) error {
+	if ctx.Err() != nil || errors.Is(err, ErrBreakerOpen) {
+		return err
+	}
+	if err != nil && !isPeerFailure(err) {
+		g.pool.report(g.addr, nil)
+	} else {
+		g.pool.report(g.addr, err)
+	}
+	return err
+}
+
+// Close 关闭被包装的客户端持有的连接
+func (g *loadGetter) Close() error {
+	if c, ok := g.PeerGetter.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
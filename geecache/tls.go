@@ -0,0 +1,204 @@
+package geecache
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// PeerTLS 节点间 TLS 配置，集群成员使用同一 CA 签发的证书，既作为服务端证书也作为客户端证书
+// 证书、私钥及 CA 从文件加载，每次握手前检查文件是否变化，变化后重新加载，证书轮换无需重启节点
+type PeerTLS struct {
+	certFile          string
+	keyFile           string
+	caFile            string // 为空时使用系统根证书校验对端
+	requireClientCert bool   // 服务端是否要求并校验客户端证书（双向 TLS）
+
+	mu     sync.Mutex
+	cert   *tls.Certificate
+	roots  *x509.CertPool       // nil 表示使用系统根证书
+	stamps map[string]fileStamp // 上次加载时各文件的状态
+}
+
+// fileStamp 文件状态，修改时间或大小变化视为文件已更新
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// NewPeerTLS 从文件加载节点证书 certFile、私钥 keyFile 及用于校验对端的 caFile
+// requireClientCert 为 true 时开启双向 TLS，只有持有 caFile 签发的证书的集群成员才能访问节点，此时 caFile 不能为空
+func NewPeerTLS(certFile, keyFile, caFile string, requireClientCert bool) (*PeerTLS, error) {
+	if requireClientCert && caFile == "" {
+		return nil, errors.New("geecache: client certificates require a CA file")
+	}
+	t := &PeerTLS{certFile: certFile, keyFile: keyFile, caFile: caFile, requireClientCert: requireClientCert}
+	if err := t.load(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// ServerConfig 返回节点服务端使用的 tls.Config，每次握手使用最新的证书和 CA
+func (t *PeerTLS) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) { //http.Server.ListenAndServeTLS 要求配置了证书
+			cert, _ := t.current()
+			return cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, roots := t.current()
+			cfg := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*cert},
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if t.requireClientCert {
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+				cfg.ClientCAs = roots
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig 返回访问远程节点使用的 tls.Config，出示节点证书，并按最新的 CA 校验对端证书是否签发给 ServerName
+// 以 IP 访问节点时 tls 不记录 ServerName，无法校验对端地址，此时拒绝连接，应改用 DialTLSContext
+func (t *PeerTLS) ClientConfig() *tls.Config {
+	return t.clientConfig("")
+}
+
+// DialTLSContext 与远程节点 addr 建立 TLS 连接，并校验对端证书是否签发给 addr 中的主机名或 IP，
+// 可用作 http.Transport.DialTLSContext，通过 ALPN 协商 HTTP/2
+func (t *PeerTLS) DialTLSContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+	cfg := t.clientConfig(host)
+	cfg.NextProtos = []string{"h2", "http/1.1"}
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// clientConfig 返回校验对端证书是否签发给 host 的 tls.Config，host 为空时使用握手记录的 ServerName
+func (t *PeerTLS) clientConfig(host string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: host,
+		// 默认校验使用固定的 RootCAs，CA 重新加载后不生效，改由 VerifyConnection 校验
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := t.current()
+			return cert, nil
+		},
+		VerifyConnection: func(cs tls.ConnectionState) error {
+			name := host
+			if name == "" {
+				name = cs.ServerName
+			}
+			_, roots := t.current()
+			return verifyPeer(cs, roots, name)
+		},
+	}
+}
+
+// verifyPeer 使用 roots 校验对端证书链，并校验证书是否签发给 host（主机名或 IP）
+func verifyPeer(cs tls.ConnectionState, roots *x509.CertPool, host string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("geecache: peer presented no certificate")
+	}
+	if host == "" { //x509 在 DNSName 为空时不校验地址，任何受信任的证书都能冒充该节点
+		return errors.New("geecache: peer address unknown, dial with PeerTLS.DialTLSContext")
+	}
+	opts := x509.VerifyOptions{
+		DNSName:       host,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// current 返回当前的证书和 CA，文件变化时先重新加载，加载失败时继续使用旧的证书
+func (t *PeerTLS) current() (*tls.Certificate, *x509.CertPool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.changed() {
+		if err := t.load(); err != nil {
+			log.Println("[GeeCache] Failed to reload TLS certificates", err)
+		} else {
+			log.Println("[GeeCache] TLS certificates reloaded")
+		}
+	}
+	return t.cert, t.roots
+}
+
+// changed 判断证书、私钥或 CA 文件自上次加载后是否变化，调用方需持有 t.mu
+func (t *PeerTLS) changed() bool {
+	for file, stamp := range t.stamps {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue //文件轮换过程中可能暂时不存在，下次握手再检查
+		}
+		if !info.ModTime().Equal(stamp.modTime) || info.Size() != stamp.size {
+			return true
+		}
+	}
+	return false
+}
+
+// load 加载证书、私钥及 CA，全部成功后才替换，调用方需持有 t.mu
+func (t *PeerTLS) load() error {
+	stamps := make(map[string]fileStamp, 3)
+	files := []string{t.certFile, t.keyFile}
+	if t.caFile != "" {
+		files = append(files, t.caFile)
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		stamps[file] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+	}
+
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return err
+	}
+	var roots *x509.CertPool
+	if t.caFile != "" {
+		pem, err := ioutil.ReadFile(t.caFile)
+		if err != nil {
+			return err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("geecache: no certificates found in %s", t.caFile)
+		}
+	}
+	t.cert, t.roots, t.stamps = &cert, roots, stamps
+	return nil
+}
@@ -1,13 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"geecache/discovery"
 	"geecache/geecache"
+	pb "geecache/geecachepb"
+	"google.golang.org/grpc"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"strings"
+	"time"
 )
 
 // 本机可用于与其他节点互通的ip
@@ -18,14 +25,54 @@ var ip = "127.0.0.1"
 // ./server.exe -port=8002
 // ./server.exe -port=8003 -api=9999
 // curl "http://localhost:9999/api?key=Tom"
+// 节点间使用 gRPC 通讯时，集群内所有节点都需要加上 -transport=grpc
+// 没有etcd时可指定固定的节点列表 ./server.exe -port=8001 -peers=127.0.0.1:8001,127.0.0.1:8002
 func main() {
-	var port string     //geecache 服务端口
-	var api string      //geecache http服务端口
-	var etcdAddr string //etcd地址
+	var port string                       //geecache 服务端口
+	var api string                        //geecache http服务端口
+	var etcdAddr string                   //etcd地址
+	var transport string                  //节点间通讯方式
+	var peerAddrs string                  //固定的集群节点列表
+	var loadFactor float64                //有界负载系数
+	var weight int                        //节点权重
+	var selector string                   //节点选择算法
+	var replication int                   //副本数
+	var hedgeDelay time.Duration          //对冲请求延迟
+	var handoffRate int                   //哈希环变动后每秒最多迁移的key数
+	var healthInterval time.Duration      //健康检查探测间隔
+	var healthThreshold int               //连续失败多少次后移出节点
+	var policy geecache.Policy            //节点请求策略
+	var httpOpts geecache.HTTPPoolOptions //节点间 HTTP 通讯配置
+	var tlsCert, tlsKey, tlsCA string     //节点证书、私钥及 CA 文件
+	var tlsClientAuth bool                //是否要求客户端证书
+	var authSecret string                 //固定节点列表时节点间请求签名的共享密钥
 	flag.StringVar(&port, "port", "", "Geecache server port")
 	flag.StringVar(&api, "api", "", "http api port")
 	flag.StringVar(&etcdAddr, "etcd", "http://127.0.0.1:2379", "etcd addr eg: http://127.0.0.1:2379")
+	flag.StringVar(&transport, "transport", "http", "peer transport: http or grpc")
+	flag.StringVar(&peerAddrs, "peers", "", "static peer addrs without etcd, eg: 127.0.0.1:8001,127.0.0.1:8002")
+	flag.Float64Var(&loadFactor, "loadFactor", 0, "bounded-load factor of consistent hashing, eg: 1.25, 0 means disabled")
+	flag.StringVar(&selector, "selector", "ring", "node selection algorithm: ring, rendezvous, jump or maglev, jump reshuffles most keys when a node other than the last by address leaves, use it only if nodes are never removed")
+	flag.IntVar(&replication, "replication", 1, "number of nodes each key is stored on, 1 means no replication")
+	flag.DurationVar(&hedgeDelay, "hedgeDelay", 0, "delay before a hedged request is sent to the next replica or the local getter, eg: 50ms, 0 means disabled")
+	flag.IntVar(&handoffRate, "handoffRate", 0, "keys per second handed off to new owners when the ring changes, 0 means disabled")
+	flag.DurationVar(&healthInterval, "healthInterval", 0, "interval of active peer health probes, eg: 2s, 0 means passive only, ejected peers then get a trial request every 5s")
+	flag.IntVar(&healthThreshold, "healthThreshold", 0, "consecutive failures before a peer is ejected, 0 means disabled")
+	flag.DurationVar(&policy.Timeout, "peerTimeout", 0, "timeout of a single request to a peer, eg: 500ms, 0 means no timeout")
+	flag.IntVar(&policy.Retries, "peerRetries", 0, "retries of a get that failed because the peer is unreachable, timed out or returned 502/503/504, backing off exponentially from 50ms")
+	flag.IntVar(&policy.FailureThreshold, "breakerThreshold", 0, "consecutive unreachable, timed out or 502/503/504 requests before the circuit breaker of a peer opens, 0 means disabled")
+	flag.DurationVar(&policy.Cooldown, "breakerCooldown", 5*time.Second, "how long an open circuit breaker waits before letting a probe request through")
+	flag.IntVar(&httpOpts.MaxIdleConnsPerHost, "maxIdleConnsPerHost", 0, "idle keep-alive connections kept per peer, 0 means 64")
+	flag.IntVar(&httpOpts.MaxConnsPerHost, "maxConnsPerHost", 0, "max connections per peer, 0 means no limit")
+	flag.BoolVar(&httpOpts.H2C, "h2c", false, "use cleartext HTTP/2 between peers, all nodes must agree")
+	flag.StringVar(&tlsCert, "tlsCert", "", "peer certificate file, enables https between peers, all nodes must agree")
+	flag.StringVar(&tlsKey, "tlsKey", "", "peer private key file")
+	flag.StringVar(&tlsCA, "tlsCA", "", "CA file used to verify other peers, empty means system roots")
+	flag.BoolVar(&tlsClientAuth, "tlsClientAuth", false, "require peers to present a certificate signed by -tlsCA")
+	flag.StringVar(&authSecret, "authSecret", "", "shared secret signing peer requests with -peers, with etcd put it at "+discovery.AuthSecret+" instead")
+	flag.IntVar(&weight, "weight", 1, "node weight in consistent hashing, set by cache capacity, eg: 4GB node 1, 16GB node 4")
 	flag.Parse()
+	policy.Backoff = 50 * time.Millisecond
 	//port = "8888"
 	//api = "9999"
 
@@ -35,27 +82,99 @@ func main() {
 	}
 	addr := ip + ":" + port
 
-	// 初始化etcd客户端
-	err := discovery.InitEtcdService([]string{etcdAddr}, 3)
-	if err != nil {
-		log.Fatal(err.Error())
+	// GRPCPool 不支持节点间 TLS，拒绝启动而不是静默地使用明文通讯
+	if transport == "grpc" && (tlsCert != "" || tlsKey != "" || tlsCA != "" || tlsClientAuth) {
+		log.Fatal("-transport=grpc 不支持节点间 TLS，请去掉 -tlsCert、-tlsKey、-tlsCA、-tlsClientAuth")
+	}
+
+	// 服务发现，指定了固定的节点列表时不依赖etcd
+	var d discovery.Discovery
+	if peerAddrs != "" {
+		static := discovery.NewStatic(strings.Split(peerAddrs, ",")...)
+		static.SetConfig(discovery.AuthSecret, authSecret)
+		d = static
+	} else {
+		// 初始化etcd客户端
+		if err := discovery.InitEtcdService([]string{etcdAddr}, 3); err != nil {
+			log.Fatal(err.Error())
+		}
+		d = discovery.NewEtcdDiscovery(addr, weight, 3)
+	}
+
+	// GRPCPool 不支持节点间请求认证，集群要求认证时拒绝启动，避免节点端口在运维以为受保护的情况下对外开放
+	if transport == "grpc" {
+		secret, err := d.GetConfig(discovery.AuthSecret)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if secret != "" {
+			log.Fatal("-transport=grpc 不支持节点间请求认证，请去掉 -authSecret 或集群配置 " + discovery.AuthSecret)
+		}
 	}
 
 	// 服务注册
-	register := discovery.NewRegister(addr)
-	if err = register.Register(3); err != nil {
+	err := d.Register()
+	if err != nil {
 		log.Fatal(err.Error())
 	}
 
-	// 通过etcd获取集群中其他节点信息，为每个节点创建http客户端 存放在 HTTPPool
-	peers := geecache.NewHTTPPool(addr, register)
-	if err = peers.Work(); err != nil {
-		log.Fatal(err.Error())
+	// 通过etcd获取集群中其他节点信息，为每个节点创建客户端 存放在 HTTPPool/GRPCPool
+	var peers geecache.PeerPicker
+	var serve func() error // 启动缓存服务
+	switch transport {
+	case "http":
+		if tlsCert != "" {
+			if httpOpts.TLS, err = geecache.NewPeerTLS(tlsCert, tlsKey, tlsCA, tlsClientAuth); err != nil {
+				log.Fatal(err.Error())
+			}
+		}
+		pool := geecache.NewHTTPPoolOpts(addr, d, &httpOpts)
+		pool.SetLoadFactor(loadFactor)
+		pool.SetHandoffRate(handoffRate)
+		pool.SetHealthCheck(healthInterval, healthThreshold)
+		pool.SetPolicy(policy)
+		if err = pool.SetSelector(selector); err != nil {
+			log.Fatal(err.Error())
+		}
+		if err = pool.Work(); err != nil {
+			log.Fatal(err.Error())
+		}
+		peers = pool
+		serve = func() error {
+			return pool.ListenAndServe(":" + port)
+		}
+	case "grpc":
+		pool := geecache.NewGRPCPool(addr, d)
+		pool.SetLoadFactor(loadFactor)
+		pool.SetHandoffRate(handoffRate)
+		pool.SetHealthCheck(healthInterval, healthThreshold)
+		pool.SetPolicy(policy)
+		if err = pool.SetSelector(selector); err != nil {
+			log.Fatal(err.Error())
+		}
+		if err = pool.Work(); err != nil {
+			log.Fatal(err.Error())
+		}
+		peers = pool
+		serve = func() error {
+			lis, err := net.Listen("tcp", ":"+port)
+			if err != nil {
+				return err
+			}
+			server := grpc.NewServer()
+			pb.RegisterGroupCacheServer(server, pool)
+			return server.Serve(lis)
+		}
+	default:
+		fmt.Println("-transport，只支持 http 或 grpc")
+		os.Exit(-1)
 	}
 
 	// 创建命名空间，以及为该命名空间准备数据源
 	gee := geecache.NewGroup("scores", 2<<10, scoresDb())
-	gee.RegisterPeers(peers) //当key对应的缓存不在本地节点，通过 peers(httpPool) 计算key拿到对应的http客户端请求远程节点缓存
+	gee.RegisterPeers(peers) //当key对应的缓存不在本地节点，通过 peers 计算key拿到对应的客户端请求远程节点缓存
+	gee.SetReplication(replication)
+	gee.SetHedgeDelay(hedgeDelay)
 
 	// 启动http服务
 	if api != "" {
@@ -63,8 +182,8 @@ func main() {
 	}
 
 	// 启动缓存服务
-	log.Println("Geecache server is running at port:", port)
-	log.Fatal(http.ListenAndServe(":"+port, peers))
+	log.Printf("Geecache %s server is running at port: %s", transport, port)
+	log.Fatal(serve())
 }
 
 // startAPIServer 用来启动一个 API 服务
@@ -72,7 +191,11 @@ func startAPIServer(port string, gee *geecache.Group) {
 	http.Handle("/api", http.HandlerFunc(
 		func(w http.ResponseWriter, r *http.Request) {
 			key := r.URL.Query().Get("key")
-			view, err := gee.Get(key)
+			view, err := gee.Get(r.Context(), key)
+			if errors.Is(err, geecache.ErrNotFound) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
@@ -96,11 +219,11 @@ func scoresDb() geecache.GetterFunc {
 		"Lbj":  "23",
 		"Liu":  "55",
 	}
-	return func(key string) ([]byte, error) {
+	return func(ctx context.Context, key string) ([]byte, error) {
 		log.Println("[SlowDB] search key", key)
 		if v, ok := db[key]; ok {
 			return []byte(v), nil
 		}
-		return nil, fmt.Errorf("%s not exist", key)
+		return nil, fmt.Errorf("%s not exist: %w", key, geecache.ErrNotFound)
 	}
 }
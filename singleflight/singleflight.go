@@ -1,12 +1,18 @@
 package singleflight
 
-import "sync"
+import (
+	"context"
+	"sync"
+	"time"
+)
 
 // call 代表正在进行中，或已经结束的请求
 type call struct {
-	wg  sync.WaitGroup
-	val interface{}
-	err error
+	done   chan struct{}      // fn 调用结束后关闭
+	val    interface{}        // fn 返回值
+	err    error              // fn 返回的错误
+	refs   int                // 仍在等待结果的调用方数量
+	cancel context.CancelFunc // 取消 fn 使用的上下文
 }
 
 // Group 管理不同 key 的请求(call)
@@ -15,9 +21,11 @@ type Group struct {
 	m  map[string]*call
 }
 
-// Do 方法，接收 2 个参数，第一个参数是 key，第二个参数是一个函数 fn。
+// Do 方法，接收 3 个参数，第一个参数是调用方的上下文，第二个参数是 key，第三个参数是一个函数 fn。
 // Do 的作用就是，针对相同的 key，无论 Do 被调用多少次，函数 fn 都只会被调用一次，等待 fn 调用结束了，返回返回值或错误。
-func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, error) {
+// 某个调用方的 ctx 被取消时，该调用方立即返回 ctx.Err()，但不影响其他仍在等待的调用方；
+// 只有所有调用方都取消后，fn 使用的上下文才会被取消。
+func (g *Group) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
 	g.mu.Lock()
 
 	// 延迟初始化，提高内存使用效率
@@ -25,23 +33,67 @@ func (g *Group) Do(key string, fn func() (interface{}, error)) (interface{}, err
 		g.m = make(map[string]*call)
 	}
 
-	if c, ok := g.m[key]; ok { // 存在key，表示有请求进行中
+	c, ok := g.m[key]
+	if ok { // 存在key，表示有请求进行中
+		c.refs++
 		g.mu.Unlock()
-		c.wg.Wait() // 等待请求，返回结果
-		return c.val, c.err
+		return g.wait(ctx, key, c) // 等待请求，返回结果
 	}
 
-	c := new(call)
-	c.wg.Add(1)  // 发起请求前加锁
+	// fn 使用的上下文与调用方的取消、超时解绑，只保留其中的值
+	fnCtx, cancel := context.WithCancel(detach(ctx))
+	c = &call{done: make(chan struct{}), refs: 1, cancel: cancel}
 	g.m[key] = c // 添加到 g.m，表明 key 已经有对应的请求在处理
 	g.mu.Unlock()
 
-	c.val, c.err = fn() // 调用 fn，发起请求
-	c.wg.Done()         // 请求结束
+	go g.doCall(fnCtx, key, c, fn) // 调用 fn，发起请求
+	return g.wait(ctx, key, c)
+}
+
+// doCall 调用 fn，结束后唤醒所有等待的调用方
+func (g *Group) doCall(ctx context.Context, key string, c *call, fn func(ctx context.Context) (interface{}, error)) {
+	c.val, c.err = fn(ctx)
+	c.cancel()
 
 	g.mu.Lock()
-	delete(g.m, key) // 更新 g.m
+	if g.m[key] == c { // 更新 g.m
+		delete(g.m, key)
+	}
 	g.mu.Unlock()
+	close(c.done) // 请求结束
+}
+
+// wait 等待 fn 调用结束或调用方取消
+func (g *Group) wait(ctx context.Context, key string, c *call) (interface{}, error) {
+	select {
+	case <-c.done:
+		return c.val, c.err
+	case <-ctx.Done():
+		g.mu.Lock()
+		c.refs--
+		if c.refs == 0 { // 已没有调用方需要该结果，取消 fn，后续调用方重新发起请求
+			c.cancel()
+			if g.m[key] == c {
+				delete(g.m, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// detachedContext 只保留父上下文中的值，不继承其取消和超时
+type detachedContext struct {
+	parent context.Context
+}
+
+func detach(ctx context.Context) context.Context {
+	return detachedContext{parent: ctx}
+}
 
-	return c.val, c.err
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (d detachedContext) Value(key interface{}) interface{} {
+	return d.parent.Value(key)
 }
@@ -0,0 +1,91 @@
+package singleflight
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 测试相同 key 的并发请求只调用一次 fn
+func TestDo(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "bar", nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if v, err := g.Do(context.Background(), "key", fn); err != nil || v.(string) != "bar" {
+				t.Errorf("Do = %v, %v", v, err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("fn should be called once, got %d", calls)
+	}
+}
+
+// 测试一个调用方取消不影响其他调用方，所有调用方取消后 fn 的上下文被取消
+func TestDoCancel(t *testing.T) {
+	var g Group
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fnCanceled := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(started)
+		select {
+		case <-release:
+			return "bar", nil
+		case <-ctx.Done():
+			close(fnCanceled)
+			return nil, ctx.Err()
+		}
+	}
+
+	ctx1, cancel1 := context.WithCancel(context.Background())
+	ctx2, cancel2 := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, err := g.Do(ctx1, "key", fn)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, err := g.Do(ctx2, "key", fn)
+		errs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	cancel1()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("canceled caller should get context.Canceled, got %v", err)
+	}
+	select {
+	case <-fnCanceled:
+		t.Fatalf("fn should keep running while another caller waits")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancel2()
+	if err := <-errs; err != context.Canceled {
+		t.Fatalf("canceled caller should get context.Canceled, got %v", err)
+	}
+	select {
+	case <-fnCanceled:
+	case <-time.After(time.Second):
+		t.Fatalf("fn should be canceled after all callers gave up")
+	}
+}